@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/gorilla/mux"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/auth"
+)
+
+// BindDetails is brokerapi.BindDetails extended with the request fields added
+// to the OSB API after the vendored brokerapi was released.
+// OriginatingIdentity is the request's X-Broker-API-Originating-Identity
+// header.
+type BindDetails struct {
+	brokerapi.BindDetails
+	BindResource         *BindResource   `json:"bind_resource,omitempty"`
+	RawContext           json.RawMessage `json:"context,omitempty"`
+	PredecessorBindingID string          `json:"predecessor_binding_id,omitempty"`
+	OriginatingIdentity  string          `json:"-"`
+}
+
+// BindResource is the bind_resource object sent by Cloud Foundry. It carries
+// the space of the app being bound, but not the space of a service key. For
+// shared service instances that is not the instance's space.
+type BindResource struct {
+	AppGuid   string `json:"app_guid,omitempty"`
+	Route     string `json:"route,omitempty"`
+	SpaceGuid string `json:"space_guid,omitempty"`
+}
+
+// PlatformContext is the context object that Cloud Foundry sends with OSB
+// requests.
+type PlatformContext struct {
+	Platform         string `json:"platform"`
+	OrganizationGUID string `json:"organization_guid"`
+	OrganizationName string `json:"organization_name"`
+	SpaceGUID        string `json:"space_guid"`
+	SpaceName        string `json:"space_name"`
+	InstanceName     string `json:"instance_name"`
+}
+
+// PlatformContext returns the request's context object, which is empty if
+// the platform did not send one.
+func (d BindDetails) PlatformContext() (PlatformContext, error) {
+	platformContext := PlatformContext{}
+	if len(d.RawContext) == 0 {
+		return platformContext, nil
+	}
+
+	if err := json.Unmarshal(d.RawContext, &platformContext); err != nil {
+		return PlatformContext{}, brokerapi.NewFailureResponse(
+			fmt.Errorf("Invalid context: %s", err), http.StatusBadRequest, "invalid-context",
+		)
+	}
+	return platformContext, nil
+}
+
+// OriginatingUserID returns the GUID of the Cloud Foundry user who made the
+// request, or "" if the platform did not identify one.
+func (d BindDetails) OriginatingUserID() (string, error) {
+	platform, value, ok := strings.Cut(d.OriginatingIdentity, " ")
+	if !ok || platform != "cloudfoundry" {
+		return "", nil
+	}
+
+	identity := struct {
+		UserID string `json:"user_id"`
+	}{}
+	buf, err := base64.StdEncoding.DecodeString(value)
+	if err == nil {
+		err = json.Unmarshal(buf, &identity)
+	}
+	if err != nil {
+		return "", brokerapi.NewFailureResponse(
+			fmt.Errorf("Invalid originating identity: %s", err), http.StatusBadRequest, "invalid-originating-identity",
+		)
+	}
+	return identity.UserID, nil
+}
+
+// Binding is the result of a bind request. Asynchronous binds return only
+// OperationData; the credentials are fetched later through GetBinding.
+// AlreadyExists marks a repeated request for an identical binding.
+type Binding struct {
+	IsAsync       bool             `json:"-"`
+	AlreadyExists bool             `json:"-"`
+	OperationData string           `json:"operation,omitempty"`
+	Credentials   interface{}      `json:"credentials,omitempty"`
+	Metadata      *BindingMetadata `json:"metadata,omitempty"`
+}
+
+// BindingMetadata is the OSB metadata of a binding.
+type BindingMetadata struct {
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+type UnbindSpec struct {
+	IsAsync       bool   `json:"-"`
+	OperationData string `json:"operation,omitempty"`
+}
+
+// ClientSecretDetails name the plan of a binding whose client secret is
+// rotated through the admin API.
+type ClientSecretDetails struct {
+	ServiceID string
+	PlanID    string
+}
+
+// ServiceBroker is brokerapi.ServiceBroker plus the asynchronous binding
+// endpoints that the vendored brokerapi does not route, and the broker's own
+// admin endpoints.
+type ServiceBroker interface {
+	brokerapi.ServiceBroker
+
+	Catalog(ctx context.Context) []Service
+	BindAsync(ctx context.Context, instanceID, bindingID string, details BindDetails, asyncAllowed bool) (Binding, error)
+	UnbindAsync(ctx context.Context, instanceID, bindingID string, details brokerapi.UnbindDetails, asyncAllowed bool) (UnbindSpec, error)
+	GetBinding(ctx context.Context, instanceID, bindingID string) (Binding, error)
+	LastBindingOperation(ctx context.Context, instanceID, bindingID, operationData string) (brokerapi.LastOperation, error)
+	RotateClientSecret(ctx context.Context, instanceID, bindingID string, details ClientSecretDetails) (Binding, error)
+	RevokeClientSecret(ctx context.Context, instanceID, bindingID string, details ClientSecretDetails) error
+}
+
+// NewAPI returns the broker's HTTP handler. Routes handled here take
+// precedence over the ones registered by brokerapi.AttachRoutes.
+func NewAPI(serviceBroker ServiceBroker, logger lager.Logger, brokerCredentials brokerapi.BrokerCredentials) http.Handler {
+	router := mux.NewRouter()
+	handler := apiHandler{serviceBroker: serviceBroker, logger: logger}
+
+	router.HandleFunc("/v2/catalog", handler.catalog).Methods("GET")
+	router.HandleFunc("/v2/service_instances/{instance_id}/service_bindings/{binding_id}", handler.bind).Methods("PUT")
+	router.HandleFunc("/v2/service_instances/{instance_id}/service_bindings/{binding_id}", handler.unbind).Methods("DELETE")
+	router.HandleFunc("/v2/service_instances/{instance_id}/service_bindings/{binding_id}", handler.getBinding).Methods("GET")
+	router.HandleFunc("/v2/service_instances/{instance_id}/service_bindings/{binding_id}/last_operation", handler.lastBindingOperation).Methods("GET")
+	router.HandleFunc("/admin/service_instances/{instance_id}/service_bindings/{binding_id}/client_secret", handler.rotateClientSecret).Methods("PUT")
+	router.HandleFunc("/admin/service_instances/{instance_id}/service_bindings/{binding_id}/client_secret", handler.revokeClientSecret).Methods("DELETE")
+
+	brokerapi.AttachRoutes(router, serviceBroker, logger)
+	return auth.NewWrapper(brokerCredentials.Username, brokerCredentials.Password).Wrap(withRequestIdentity(router))
+}
+
+type requestIdentityKey struct{}
+
+// withRequestIdentity adds the platform's X-Broker-API-Request-Identity
+// header, if any, to the request context so that downstream calls can be
+// correlated with the OSB request.
+func withRequestIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if id := req.Header.Get("X-Broker-API-Request-Identity"); id != "" {
+			req = req.WithContext(context.WithValue(req.Context(), requestIdentityKey{}, id))
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// requestData adds the request identity in ctx, if any, to data.
+func requestData(ctx context.Context, data lager.Data) lager.Data {
+	if id, ok := ctx.Value(requestIdentityKey{}).(string); ok {
+		data["requestIdentity"] = id
+	}
+	return data
+}
+
+type catalogResponse struct {
+	Services []Service `json:"services"`
+}
+
+type apiHandler struct {
+	serviceBroker ServiceBroker
+	logger        lager.Logger
+}
+
+func (h apiHandler) catalog(w http.ResponseWriter, req *http.Request) {
+	h.respond(w, http.StatusOK, catalogResponse{
+		Services: h.serviceBroker.Catalog(req.Context()),
+	})
+}
+
+func (h apiHandler) bind(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	instanceID := vars["instance_id"]
+	bindingID := vars["binding_id"]
+
+	logger := h.logger.Session("bind", lager.Data{
+		"instance-id": instanceID,
+		"binding-id":  bindingID,
+	})
+
+	var details BindDetails
+	if err := json.NewDecoder(req.Body).Decode(&details); err != nil {
+		logger.Error("invalid-bind-details", err)
+		h.respond(w, http.StatusUnprocessableEntity, brokerapi.ErrorResponse{
+			Description: err.Error(),
+		})
+		return
+	}
+	details.OriginatingIdentity = req.Header.Get("X-Broker-API-Originating-Identity")
+
+	asyncAllowed, _ := strconv.ParseBool(req.URL.Query().Get("accepts_incomplete"))
+
+	binding, err := h.serviceBroker.BindAsync(req.Context(), instanceID, bindingID, details, asyncAllowed)
+	if err != nil {
+		h.respondError(w, logger, err)
+		return
+	}
+
+	switch {
+	case binding.IsAsync:
+		h.respond(w, http.StatusAccepted, binding)
+	case binding.AlreadyExists:
+		h.respond(w, http.StatusOK, binding)
+	default:
+		h.respond(w, http.StatusCreated, binding)
+	}
+}
+
+func (h apiHandler) unbind(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	instanceID := vars["instance_id"]
+	bindingID := vars["binding_id"]
+
+	logger := h.logger.Session("unbind", lager.Data{
+		"instance-id": instanceID,
+		"binding-id":  bindingID,
+	})
+
+	details := brokerapi.UnbindDetails{
+		PlanID:    req.FormValue("plan_id"),
+		ServiceID: req.FormValue("service_id"),
+	}
+	asyncAllowed, _ := strconv.ParseBool(req.FormValue("accepts_incomplete"))
+
+	spec, err := h.serviceBroker.UnbindAsync(req.Context(), instanceID, bindingID, details, asyncAllowed)
+	if err != nil {
+		h.respondError(w, logger, err)
+		return
+	}
+
+	if spec.IsAsync {
+		h.respond(w, http.StatusAccepted, spec)
+		return
+	}
+	h.respond(w, http.StatusOK, brokerapi.EmptyResponse{})
+}
+
+func (h apiHandler) getBinding(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	instanceID := vars["instance_id"]
+	bindingID := vars["binding_id"]
+
+	logger := h.logger.Session("get-binding", lager.Data{
+		"instance-id": instanceID,
+		"binding-id":  bindingID,
+	})
+
+	binding, err := h.serviceBroker.GetBinding(req.Context(), instanceID, bindingID)
+	if err != nil {
+		h.respondError(w, logger, err)
+		return
+	}
+
+	h.respond(w, http.StatusOK, binding)
+}
+
+func (h apiHandler) lastBindingOperation(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	instanceID := vars["instance_id"]
+	bindingID := vars["binding_id"]
+
+	logger := h.logger.Session("last-binding-operation", lager.Data{
+		"instance-id": instanceID,
+		"binding-id":  bindingID,
+	})
+
+	lastOperation, err := h.serviceBroker.LastBindingOperation(req.Context(), instanceID, bindingID, req.FormValue("operation"))
+	if err != nil {
+		h.respondError(w, logger, err)
+		return
+	}
+
+	logger.Info("done-check-for-operation", lager.Data{"state": lastOperation.State})
+
+	h.respond(w, http.StatusOK, brokerapi.LastOperationResponse{
+		State:       lastOperation.State,
+		Description: lastOperation.Description,
+	})
+}
+
+func (h apiHandler) rotateClientSecret(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	instanceID := vars["instance_id"]
+	bindingID := vars["binding_id"]
+
+	logger := h.logger.Session("rotate-client-secret", lager.Data{
+		"instance-id": instanceID,
+		"binding-id":  bindingID,
+	})
+
+	details := ClientSecretDetails{
+		PlanID:    req.FormValue("plan_id"),
+		ServiceID: req.FormValue("service_id"),
+	}
+
+	binding, err := h.serviceBroker.RotateClientSecret(req.Context(), instanceID, bindingID, details)
+	if err != nil {
+		h.respondError(w, logger, err)
+		return
+	}
+
+	h.respond(w, http.StatusOK, binding)
+}
+
+func (h apiHandler) revokeClientSecret(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	instanceID := vars["instance_id"]
+	bindingID := vars["binding_id"]
+
+	logger := h.logger.Session("revoke-client-secret", lager.Data{
+		"instance-id": instanceID,
+		"binding-id":  bindingID,
+	})
+
+	details := ClientSecretDetails{
+		PlanID:    req.FormValue("plan_id"),
+		ServiceID: req.FormValue("service_id"),
+	}
+
+	if err := h.serviceBroker.RevokeClientSecret(req.Context(), instanceID, bindingID, details); err != nil {
+		h.respondError(w, logger, err)
+		return
+	}
+
+	h.respond(w, http.StatusOK, brokerapi.EmptyResponse{})
+}
+
+func (h apiHandler) respondError(w http.ResponseWriter, logger lager.Logger, err error) {
+	switch err := err.(type) {
+	case *brokerapi.FailureResponse:
+		logger.Error(err.LoggerAction(), err)
+		h.respond(w, err.ValidatedStatusCode(logger), err.ErrorResponse())
+	default:
+		logger.Error("unknown-error", err)
+		h.respond(w, http.StatusInternalServerError, brokerapi.ErrorResponse{
+			Description: err.Error(),
+		})
+	}
+}
+
+func (h apiHandler) respond(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.logger.Error("encoding-response", err, lager.Data{"status": status})
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/pivotal-cf/brokerapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+)
+
+type FakeServiceBroker struct {
+	mock.Mock
+}
+
+func (b *FakeServiceBroker) Services(ctx context.Context) []brokerapi.Service {
+	return nil
+}
+
+func (b *FakeServiceBroker) Provision(ctx context.Context, instanceID string, details brokerapi.ProvisionDetails, asyncAllowed bool) (brokerapi.ProvisionedServiceSpec, error) {
+	return brokerapi.ProvisionedServiceSpec{}, nil
+}
+
+func (b *FakeServiceBroker) Deprovision(ctx context.Context, instanceID string, details brokerapi.DeprovisionDetails, asyncAllowed bool) (brokerapi.DeprovisionServiceSpec, error) {
+	return brokerapi.DeprovisionServiceSpec{}, nil
+}
+
+func (b *FakeServiceBroker) Bind(ctx context.Context, instanceID, bindingID string, details brokerapi.BindDetails) (brokerapi.Binding, error) {
+	return brokerapi.Binding{}, nil
+}
+
+func (b *FakeServiceBroker) Unbind(ctx context.Context, instanceID, bindingID string, details brokerapi.UnbindDetails) error {
+	return nil
+}
+
+func (b *FakeServiceBroker) Update(ctx context.Context, instanceID string, details brokerapi.UpdateDetails, asyncAllowed bool) (brokerapi.UpdateServiceSpec, error) {
+	return brokerapi.UpdateServiceSpec{}, nil
+}
+
+func (b *FakeServiceBroker) LastOperation(ctx context.Context, instanceID, operationData string) (brokerapi.LastOperation, error) {
+	return brokerapi.LastOperation{}, nil
+}
+
+func (b *FakeServiceBroker) Catalog(ctx context.Context) []Service {
+	return []Service{}
+}
+
+func (b *FakeServiceBroker) BindAsync(ctx context.Context, instanceID, bindingID string, details BindDetails, asyncAllowed bool) (Binding, error) {
+	args := b.Called(instanceID, bindingID, asyncAllowed)
+	return args.Get(0).(Binding), args.Error(1)
+}
+
+func (b *FakeServiceBroker) UnbindAsync(ctx context.Context, instanceID, bindingID string, details brokerapi.UnbindDetails, asyncAllowed bool) (UnbindSpec, error) {
+	args := b.Called(instanceID, bindingID, details, asyncAllowed)
+	return args.Get(0).(UnbindSpec), args.Error(1)
+}
+
+func (b *FakeServiceBroker) GetBinding(ctx context.Context, instanceID, bindingID string) (Binding, error) {
+	args := b.Called(instanceID, bindingID)
+	return args.Get(0).(Binding), args.Error(1)
+}
+
+func (b *FakeServiceBroker) LastBindingOperation(ctx context.Context, instanceID, bindingID, operationData string) (brokerapi.LastOperation, error) {
+	args := b.Called(instanceID, bindingID, operationData)
+	return args.Get(0).(brokerapi.LastOperation), args.Error(1)
+}
+
+func (b *FakeServiceBroker) RotateClientSecret(ctx context.Context, instanceID, bindingID string, details ClientSecretDetails) (Binding, error) {
+	args := b.Called(instanceID, bindingID, details)
+	return args.Get(0).(Binding), args.Error(1)
+}
+
+func (b *FakeServiceBroker) RevokeClientSecret(ctx context.Context, instanceID, bindingID string, details ClientSecretDetails) error {
+	args := b.Called(instanceID, bindingID, details)
+	return args.Error(0)
+}
+
+var _ = Describe("api", func() {
+	var (
+		serviceBroker *FakeServiceBroker
+		server        *httptest.Server
+	)
+
+	BeforeEach(func() {
+		serviceBroker = &FakeServiceBroker{}
+		server = httptest.NewServer(NewAPI(serviceBroker, lagertest.NewTestLogger("api-test"), brokerapi.BrokerCredentials{
+			Username: "broker",
+			Password: "secret",
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	request := func(method, path, body string) (int, map[string]interface{}) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		req.SetBasicAuth("broker", "secret")
+		req.Header.Set("X-Broker-API-Version", "2.14")
+
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		buf, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+
+		response := map[string]interface{}{}
+		if len(buf) > 0 {
+			Expect(json.Unmarshal(buf, &response)).To(Succeed())
+		}
+		return resp.StatusCode, response
+	}
+
+	const bindingPath = "/v2/service_instances/instance-guid/service_bindings/binding-guid"
+
+	It("requires the broker's credentials", func() {
+		resp, err := http.Get(server.URL + "/v2/catalog")
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+	})
+
+	Describe("bind", func() {
+		It("returns 202 and the operation for asynchronous binds", func() {
+			serviceBroker.On("BindAsync", "instance-guid", "binding-guid", true).Return(Binding{IsAsync: true, OperationData: "op-id"}, nil)
+
+			status, response := request("PUT", bindingPath+"?accepts_incomplete=true", `{"service_id": "service-guid", "plan_id": "plan-guid"}`)
+			Expect(status).To(Equal(http.StatusAccepted))
+			Expect(response).To(Equal(map[string]interface{}{"operation": "op-id"}))
+		})
+
+		It("binds synchronously unless the platform accepts incomplete responses", func() {
+			serviceBroker.On("BindAsync", "instance-guid", "binding-guid", false).Return(Binding{
+				Credentials: map[string]string{"username": "binding-guid"},
+			}, nil)
+
+			status, response := request("PUT", bindingPath, `{"service_id": "service-guid", "plan_id": "plan-guid"}`)
+			Expect(status).To(Equal(http.StatusCreated))
+			Expect(response).To(HaveKeyWithValue("credentials", map[string]interface{}{"username": "binding-guid"}))
+		})
+
+		It("returns 200 for a repeated bind", func() {
+			serviceBroker.On("BindAsync", "instance-guid", "binding-guid", false).Return(Binding{AlreadyExists: true}, nil)
+
+			status, _ := request("PUT", bindingPath, `{}`)
+			Expect(status).To(Equal(http.StatusOK))
+		})
+
+		It("returns the status of broker errors", func() {
+			serviceBroker.On("BindAsync", "instance-guid", "binding-guid", false).Return(Binding{}, brokerapi.ErrBindingAlreadyExists)
+
+			status, response := request("PUT", bindingPath, `{}`)
+			Expect(status).To(Equal(http.StatusConflict))
+			Expect(response).To(HaveKey("description"))
+		})
+
+		It("refuses malformed requests", func() {
+			status, _ := request("PUT", bindingPath, `{`)
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			serviceBroker.AssertNotCalled(GinkgoT(), "BindAsync", mock.Anything, mock.Anything, mock.Anything)
+		})
+	})
+
+	Describe("unbind", func() {
+		details := brokerapi.UnbindDetails{ServiceID: "service-guid", PlanID: "plan-guid"}
+
+		It("returns 202 and the operation for asynchronous unbinds", func() {
+			serviceBroker.On("UnbindAsync", "instance-guid", "binding-guid", details, true).Return(UnbindSpec{IsAsync: true, OperationData: "op-id"}, nil)
+
+			status, response := request("DELETE", bindingPath+"?service_id=service-guid&plan_id=plan-guid&accepts_incomplete=true", ``)
+			Expect(status).To(Equal(http.StatusAccepted))
+			Expect(response).To(Equal(map[string]interface{}{"operation": "op-id"}))
+		})
+
+		It("returns 200 for synchronous unbinds", func() {
+			serviceBroker.On("UnbindAsync", "instance-guid", "binding-guid", details, false).Return(UnbindSpec{}, nil)
+
+			status, response := request("DELETE", bindingPath+"?service_id=service-guid&plan_id=plan-guid", ``)
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(response).To(BeEmpty())
+		})
+
+		It("returns 410 for bindings that do not exist", func() {
+			serviceBroker.On("UnbindAsync", "instance-guid", "binding-guid", details, false).Return(UnbindSpec{}, brokerapi.ErrBindingDoesNotExist)
+
+			status, response := request("DELETE", bindingPath+"?service_id=service-guid&plan_id=plan-guid", ``)
+			Expect(status).To(Equal(http.StatusGone))
+			Expect(response).To(BeEmpty())
+		})
+	})
+
+	Describe("get binding", func() {
+		It("returns the binding's credentials", func() {
+			serviceBroker.On("GetBinding", "instance-guid", "binding-guid").Return(Binding{
+				Credentials: map[string]string{"username": "binding-guid"},
+			}, nil)
+
+			status, response := request("GET", bindingPath, ``)
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(response).To(HaveKeyWithValue("credentials", map[string]interface{}{"username": "binding-guid"}))
+		})
+
+		It("returns 404 for unknown bindings", func() {
+			serviceBroker.On("GetBinding", "instance-guid", "binding-guid").Return(Binding{}, brokerapi.NewFailureResponse(
+				errors.New("Binding binding-guid not found"), http.StatusNotFound, "get-binding",
+			))
+
+			status, response := request("GET", bindingPath, ``)
+			Expect(status).To(Equal(http.StatusNotFound))
+			Expect(response).To(HaveKeyWithValue("description", "Binding binding-guid not found"))
+		})
+	})
+
+	Describe("binding last operation", func() {
+		It("returns the operation's state", func() {
+			serviceBroker.On("LastBindingOperation", "instance-guid", "binding-guid", "op-id").Return(brokerapi.LastOperation{
+				State:       brokerapi.Failed,
+				Description: "UAA unavailable",
+			}, nil)
+
+			status, response := request("GET", bindingPath+"/last_operation?operation=op-id", ``)
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(response).To(Equal(map[string]interface{}{"state": "failed", "description": "UAA unavailable"}))
+		})
+	})
+
+	Describe("originating identity", func() {
+		It("returns the Cloud Foundry user who made the request", func() {
+			details := BindDetails{OriginatingIdentity: "cloudfoundry eyJ1c2VyX2lkIjoicmVxdWVzdGVyLWd1aWQifQ=="}
+			Expect(details.OriginatingUserID()).To(Equal("requester-guid"))
+		})
+
+		It("returns no user for other platforms", func() {
+			details := BindDetails{OriginatingIdentity: "kubernetes eyJ1c2VybmFtZSI6ImFkbWluIn0="}
+			Expect(details.OriginatingUserID()).To(BeEmpty())
+		})
+
+		It("refuses malformed identities", func() {
+			details := BindDetails{OriginatingIdentity: "cloudfoundry not-base64"}
+			_, err := details.OriginatingUserID()
+			Expect(err).To(MatchError(ContainSubstring("Invalid originating identity")))
+		})
+	})
+
+	Describe("client secrets", func() {
+		const secretPath = "/admin/service_instances/instance-guid/service_bindings/binding-guid/client_secret?service_id=service-guid&plan_id=plan-guid"
+		details := ClientSecretDetails{ServiceID: "service-guid", PlanID: "plan-guid"}
+
+		It("rotates the secret", func() {
+			serviceBroker.On("RotateClientSecret", "instance-guid", "binding-guid", details).Return(Binding{
+				Credentials: map[string]string{"client_id": "binding-guid", "client_secret": "new-secret"},
+			}, nil)
+
+			status, response := request("PUT", secretPath, ``)
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(response).To(HaveKeyWithValue("credentials", HaveKeyWithValue("client_secret", "new-secret")))
+		})
+
+		It("revokes the previous secret", func() {
+			serviceBroker.On("RevokeClientSecret", "instance-guid", "binding-guid", details).Return(nil)
+
+			status, _ := request("DELETE", secretPath, ``)
+			Expect(status).To(Equal(http.StatusOK))
+			serviceBroker.AssertExpectations(GinkgoT())
+		})
+
+		It("returns the status of broker errors", func() {
+			serviceBroker.On("RevokeClientSecret", "instance-guid", "binding-guid", details).Return(brokerapi.NewFailureResponse(
+				errors.New("Client binding-guid has no previous secret"), http.StatusConflict, "revoke-client-secret",
+			))
+
+			status, response := request("DELETE", secretPath, ``)
+			Expect(status).To(Equal(http.StatusConflict))
+			Expect(response).To(HaveKeyWithValue("description", "Client binding-guid has no previous secret"))
+		})
+	})
+})
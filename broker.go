@@ -7,254 +7,1476 @@ import (
 	"fmt"
 
 	"code.cloudfoundry.org/lager"
+	cf "github.com/cloudfoundry/go-cfclient/v3/resource"
 	"github.com/pivotal-cf/brokerapi"
 
-	"io/ioutil"
-	"os"
-	"path/filepath"
+	"net/http"
+	"reflect"
+	"sort"
 	"strings"
+	"time"
 )
 
 type BindOptions struct {
 	RedirectURI []string `json:"redirect_uri"`
 	Scopes      []string `json:"scopes"`
 	AllowPublic *bool    `json:"allowpublic"`
+	GrantTypes  []string `json:"grant_types,omitempty"`
+	Authorities []string `json:"authorities,omitempty"`
 }
 
-var (
-	clientAccountGUID = "6b508bb8-2af7-4a75-9efd-7b76a01d705d"
-	userAccountGUID   = "964bd86d-72fa-4852-957f-e4cd802de34b"
-	deployerGUID      = "074e652b-b77b-4ac3-8d5b-52144486b1a3"
-	auditorGUID       = "dc3a6d48-9622-434a-b418-1d920193b575"
-)
-
-var (
-	defaultScopes = []string{"openid"}
-	allowedScopes = map[string]bool{
-		"openid": true,
-	}
-)
-
 type DeployerAccountBroker struct {
 	uaaClient        AuthClient
 	cfClient         PAASClient
 	generatePassword PasswordGenerator
 	logger           lager.Logger
 	config           Config
+	operations       *OperationStore
+	plans            *PlanRegistry
+	oidc             OIDCEndpoints
 }
 
-func (b *DeployerAccountBroker) Services(context context.Context) []brokerapi.Service {
-	var services []brokerapi.Service
-	pwd, _ := os.Getwd()
+func (b *DeployerAccountBroker) Catalog(ctx context.Context) []Service {
+	return b.plans.Services()
+}
 
-	buf, err := ioutil.ReadFile(filepath.Join(pwd, "config.json"))
-	if err != nil {
-		b.logger.Error("services", err)
-		return []brokerapi.Service{}
-	}
-	err = json.Unmarshal(buf, &services)
-	if err != nil {
-		return []brokerapi.Service{}
+func (b *DeployerAccountBroker) Services(ctx context.Context) []brokerapi.Service {
+	services := []brokerapi.Service{}
+	for _, service := range b.Catalog(ctx) {
+		service.Service.Plans = []brokerapi.ServicePlan{}
+		for _, plan := range service.Plans {
+			service.Service.Plans = append(service.Service.Plans, plan.ServicePlan)
+		}
+		services = append(services, service.Service)
 	}
 	return services
 }
 
 func (b *DeployerAccountBroker) Provision(
-	context context.Context,
+	ctx context.Context,
 	instanceID string,
 	details brokerapi.ProvisionDetails,
 	asyncAllowed bool,
 ) (brokerapi.ProvisionedServiceSpec, error) {
+	plan, err := b.offeredPlan(details.ServiceID, details.PlanID)
+	if err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	if details.OrganizationGUID == "" || details.SpaceGUID == "" {
+		return brokerapi.ProvisionedServiceSpec{}, brokerapi.NewFailureResponse(
+			errors.New("Provisioning requires an organization_guid and space_guid"),
+			http.StatusBadRequest, "missing-org-or-space",
+		)
+	}
+	if err := b.checkOrg(ctx, plan.PlanRef, details.OrganizationGUID, ""); err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+
+	if err := plan.ProvisionSchema.Validate(details.RawParameters); err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	defaults, err := parseProvisionOptions(plan, details)
+	if err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	if defaults.empty() {
+		return brokerapi.ProvisionedServiceSpec{}, nil
+	}
+	target := clientTarget{SpaceID: details.SpaceGUID, OrgID: details.OrganizationGUID}
+	scopes, err := b.allowedScopes(ctx, plan, &target)
+	if err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	if _, err := b.buildClient(plan, instanceID, "", defaults, scopes); err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, invalidParameters(err)
+	}
+	if err := b.checkRedirectURIs(ctx, plan, defaults.RedirectURI, &target, true); err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+
+	if err := b.storeDefaults(ctx, instanceID, defaults); err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
 	return brokerapi.ProvisionedServiceSpec{}, nil
 }
 
+// storeDefaults keeps the bind defaults of a service instance on a UAA client
+// named after the instance, which deprovisioning deletes.
+func (b *DeployerAccountBroker) storeDefaults(ctx context.Context, instanceID string, defaults BindOptions) error {
+	client := b.stateClient(instanceID)
+	client.BindDefaults = &defaults
+	_, err := b.uaaClient.CreateClient(ctx, client)
+	if !errors.Is(err, ErrUAAConflict) {
+		return err
+	}
+
+	// The platform retried a provision that already stored the defaults
+	existing, err := b.uaaClient.GetClient(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+	if existing.BindDefaults == nil || !reflect.DeepEqual(*existing.BindDefaults, defaults) {
+		return brokerapi.ErrInstanceAlreadyExists
+	}
+	return nil
+}
+
+// stateClient returns a UAA client that only keeps the broker's own state in
+// its additional information. It grants nothing and its secret is discarded.
+func (b *DeployerAccountBroker) stateClient(clientID string) Client {
+	return Client{
+		ID:                   clientID,
+		AuthorizedGrantTypes: []string{"client_credentials"},
+		Scope:                []string{uaaNone},
+		Authorities:          []string{uaaNone},
+		ClientSecret:         b.generatePassword(b.config.PasswordLength),
+	}
+}
+
 func (b *DeployerAccountBroker) Deprovision(
-	context context.Context,
+	ctx context.Context,
 	instanceID string,
 	details brokerapi.DeprovisionDetails,
 	asyncAllowed bool,
 ) (brokerapi.DeprovisionServiceSpec, error) {
+	plan, err := b.plan(details.ServiceID, details.PlanID)
+	if err != nil {
+		return brokerapi.DeprovisionServiceSpec{}, err
+	}
+
 	// Handle instances created before credential management was moved to bind and unbind
-	switch details.ServiceID {
-	case clientAccountGUID:
-		if err := b.deleteClient(instanceID); err != nil {
+	switch plan.Kind {
+	case PlanKindClient:
+		// This also deletes the client that keeps the instance's bind defaults
+		if err := b.deleteClient(ctx, instanceID); err != nil {
 			return brokerapi.DeprovisionServiceSpec{}, err
 		}
-	case userAccountGUID:
-		user, err := b.uaaClient.GetUser(instanceID)
+	case PlanKindUser:
+		user, err := b.uaaClient.GetUser(ctx, instanceID)
 		if err != nil {
-			if strings.Contains(err.Error(), "got 0") {
+			if errors.Is(err, ErrUAANotFound) {
 				return brokerapi.DeprovisionServiceSpec{}, nil
 			}
 			return brokerapi.DeprovisionServiceSpec{}, err
 		}
 
-		err = b.cfClient.DeleteUser(user.ID)
+		err = b.cfClient.DeleteUser(ctx, user.ID)
 		if err != nil {
 			return brokerapi.DeprovisionServiceSpec{}, err
 		}
 
-		err = b.uaaClient.DeleteUser(user.ID)
+		err = b.uaaClient.DeleteUser(ctx, user.ID)
 		if err != nil {
 			return brokerapi.DeprovisionServiceSpec{}, err
 		}
-	default:
-		return brokerapi.DeprovisionServiceSpec{}, fmt.Errorf("Service ID %s not found", details.ServiceID)
 	}
 
 	return brokerapi.DeprovisionServiceSpec{}, nil
 }
 
-func parseBindOptions(details brokerapi.BindDetails) (BindOptions, error) {
-	opts := BindOptions{}
+// withDefaults fills in the options that were not given from defaults. The
+// redirect URIs and allowpublic only apply to authorization code clients, so
+// they are left out for other grant types.
+func (o BindOptions) withDefaults(plan RegisteredPlan, defaults BindOptions) BindOptions {
+	if o.Scopes == nil {
+		o.Scopes = defaults.Scopes
+	}
+	if !containsString(o.grantTypes(plan), "authorization_code") {
+		return o
+	}
+	if o.RedirectURI == nil {
+		o.RedirectURI = defaults.RedirectURI
+	}
+	if o.AllowPublic == nil {
+		o.AllowPublic = defaults.AllowPublic
+	}
+	return o
+}
 
-	if len(details.RawParameters) == 0 {
-		return opts, errors.New(`must pass JSON configuration with field "redirect_uri"`)
+// grantTypes returns the grant types requested, or else the plan's.
+func (o BindOptions) grantTypes(plan RegisteredPlan) []string {
+	if len(o.GrantTypes) == 0 {
+		return plan.GrantTypes
+	}
+	return o.GrantTypes
+}
+
+func (o BindOptions) empty() bool {
+	return o.RedirectURI == nil && o.Scopes == nil && o.AllowPublic == nil
+}
+
+// complete reports whether no option is left to be filled in from defaults.
+func (o BindOptions) complete() bool {
+	return o.RedirectURI != nil && o.Scopes != nil && o.AllowPublic != nil
+}
+
+// parseProvisionOptions returns the bind defaults given when provisioning an
+// instance of a client plan. Parameters must already have been validated
+// against the plan's schema.
+func parseProvisionOptions(plan RegisteredPlan, details brokerapi.ProvisionDetails) (BindOptions, error) {
+	opts := BindOptions{}
+	if len(details.RawParameters) == 0 || plan.Kind != PlanKindClient {
+		return opts, nil
 	}
 
 	if err := json.Unmarshal(details.RawParameters, &opts); err != nil {
+		return BindOptions{}, invalidParameters(fmt.Errorf("Invalid parameters: %s", err))
+	}
+	return opts, nil
+}
+
+// parseBindOptions returns the options given when binding, filled in from the
+// instance's defaults.
+func parseBindOptions(plan RegisteredPlan, details brokerapi.BindDetails, defaults BindOptions) (BindOptions, error) {
+	opts := BindOptions{}
+
+	if len(details.RawParameters) > 0 {
+		if err := json.Unmarshal(details.RawParameters, &opts); err != nil {
+			return opts, err
+		}
+	}
+	opts = opts.withDefaults(plan, defaults)
+
+	return opts, nil
+}
+
+// bindOptions returns the options given when binding a client, filled in from
+// the instance's defaults. The defaults are only looked up if the bind left out
+// any option.
+func (b *DeployerAccountBroker) bindOptions(ctx context.Context, plan RegisteredPlan, instanceID string, details brokerapi.BindDetails) (BindOptions, error) {
+	opts, err := parseBindOptions(plan, details, BindOptions{})
+	if err != nil || opts.complete() {
 		return opts, err
 	}
 
-	if len(opts.RedirectURI) == 0 {
-		return opts, errors.New(`must pass field "redirect_uri"`)
+	defaults, err := b.instanceDefaults(ctx, instanceID)
+	if err != nil {
+		return BindOptions{}, err
+	}
+	return opts.withDefaults(plan, defaults), nil
+}
+
+// UserBindOptions are the parameters accepted when binding a service account.
+// Spaces, named by GUID or name, must be in the instance's org and are granted
+// the same roles as the instance's own space. Role or Roles pick among the
+// plan's space and requestable roles; by default the plan's space roles are
+// granted.
+type UserBindOptions struct {
+	Spaces []string `json:"spaces"`
+	Role   string   `json:"role"`
+	Roles  []string `json:"roles"`
+}
+
+// parseUserBindOptions returns the options given when binding a service
+// account. Parameters must already have been validated against the plan's
+// schema.
+func parseUserBindOptions(plan RegisteredPlan, details brokerapi.BindDetails) (UserBindOptions, error) {
+	opts := UserBindOptions{}
+	if len(details.RawParameters) > 0 {
+		if err := json.Unmarshal(details.RawParameters, &opts); err != nil {
+			return UserBindOptions{}, invalidParameters(fmt.Errorf("Invalid parameters: %s", err))
+		}
 	}
 
+	if opts.Role != "" && len(opts.Roles) > 0 {
+		return UserBindOptions{}, invalidParameters(errors.New(`Invalid parameters: pass only one of "role" and "roles"`))
+	}
+	for _, role := range opts.spaceRoles(plan) {
+		if !containsString(plan.SpaceRoles, role) && !containsString(plan.RequestableRoles, role) {
+			return UserBindOptions{}, invalidParameters(fmt.Errorf("Plan %s does not grant role %s", plan.PlanName, role))
+		}
+	}
+	if len(opts.spaceRoles(plan)) == 0 {
+		if len(plan.RequestableRoles) > 0 {
+			return UserBindOptions{}, invalidParameters(fmt.Errorf(`Plan %s requires field "roles"`, plan.PlanName))
+		}
+		if len(opts.Spaces) > 0 {
+			return UserBindOptions{}, invalidParameters(fmt.Errorf("Plan %s does not grant space roles", plan.PlanName))
+		}
+	}
 	return opts, nil
 }
 
+// spaceRoles returns the space roles to grant.
+func (o UserBindOptions) spaceRoles(plan RegisteredPlan) []string {
+	switch {
+	case len(o.Roles) > 0:
+		return o.Roles
+	case o.Role != "":
+		return []string{o.Role}
+	default:
+		return plan.SpaceRoles
+	}
+}
+
 func (b *DeployerAccountBroker) Bind(
-	context context.Context,
+	ctx context.Context,
 	instanceID, bindingID string,
 	details brokerapi.BindDetails,
 ) (brokerapi.Binding, error) {
+	binding, err := b.bind(ctx, instanceID, bindingID, BindDetails{BindDetails: details})
+	if err != nil {
+		return brokerapi.Binding{}, err
+	}
+	return brokerapi.Binding{Credentials: binding.Credentials}, nil
+}
+
+// BindAsync binds service accounts in the background when the platform
+// accepts incomplete responses; all other binds complete synchronously.
+func (b *DeployerAccountBroker) BindAsync(
+	ctx context.Context,
+	instanceID, bindingID string,
+	details BindDetails,
+	asyncAllowed bool,
+) (Binding, error) {
+	plan, err := b.offeredPlan(details.ServiceID, details.PlanID)
+	if err != nil {
+		return Binding{}, err
+	}
+	if !asyncAllowed || !plan.Kind.cfUser() {
+		return b.bind(ctx, instanceID, bindingID, details)
+	}
+
+	op, started, err := b.operations.Start(BindOperation, instanceID, bindingID)
+	if err != nil {
+		return Binding{}, err
+	}
+	if started {
+		ctx, cancel := b.backgroundContext(ctx)
+		go func() {
+			defer cancel()
+			binding, err := b.bind(ctx, instanceID, bindingID, details)
+			if err != nil {
+				b.logger.Error("async-bind", err, requestData(ctx, lager.Data{"bindingID": bindingID, "operation": op.ID}))
+			}
+			b.operations.Finish(op.ID, binding, err)
+		}()
+	}
+
+	return Binding{IsAsync: true, OperationData: op.ID}, nil
+}
+
+func (b *DeployerAccountBroker) bind(
+	ctx context.Context,
+	instanceID, bindingID string,
+	details BindDetails,
+) (Binding, error) {
+	platformContext, err := details.PlatformContext()
+	if err != nil {
+		return Binding{}, err
+	}
+	plan, err := b.offeredPlan(details.ServiceID, details.PlanID)
+	if err != nil {
+		return Binding{}, err
+	}
+	if err := plan.BindSchema.Validate(details.RawParameters); err != nil {
+		return Binding{}, err
+	}
+	expiresIn, err := b.parseExpiresIn(details.BindDetails)
+	if err != nil {
+		return Binding{}, err
+	}
+	if details.PredecessorBindingID != "" {
+		if err := b.checkPredecessor(ctx, instanceID, details.PredecessorBindingID); err != nil {
+			return Binding{}, err
+		}
+	}
+
+	binding, err := b.bindCredentials(ctx, plan, instanceID, bindingID, details, platformContext)
+	if err != nil || expiresIn == 0 {
+		return binding, err
+	}
+
+	expiresAt := time.Now().Add(expiresIn).UTC()
+	if err := b.recordExpiry(ctx, plan, bindingID, expiresAt); err != nil {
+		cleanupCtx, cancel := b.backgroundContext(ctx)
+		defer cancel()
+		if cleanupErr := b.deleteCredentials(cleanupCtx, plan, bindingID); cleanupErr != nil {
+			b.logger.Error("bind-cleanup", cleanupErr, requestData(ctx, lager.Data{"bindingID": bindingID}))
+		}
+		return Binding{}, err
+	}
+	binding.Metadata = &BindingMetadata{ExpiresAt: expiresAt.Format(time.RFC3339)}
+	return binding, nil
+}
+
+// bindCredentials creates the UAA user or client of a binding according to
+// the plan's kind.
+func (b *DeployerAccountBroker) bindCredentials(
+	ctx context.Context,
+	plan RegisteredPlan,
+	instanceID, bindingID string,
+	details BindDetails,
+	platformContext PlatformContext,
+) (Binding, error) {
+	switch plan.Kind {
+	case PlanKindClient:
+		var target clientTarget
+		policyApplies := b.config.OrgPolicy().Applies(plan.PlanRef)
+		if policyApplies || b.clientNeedsTarget(plan) {
+			spaceID, orgID, err := b.bindingTarget(ctx, instanceID, details)
+			if err != nil {
+				return Binding{}, err
+			}
+			target = clientTarget{SpaceID: spaceID, OrgID: orgID, OrgName: platformContext.OrganizationName}
+			if policyApplies {
+				if err := b.checkOrg(ctx, plan.PlanRef, orgID, platformContext.OrganizationName); err != nil {
+					return Binding{}, err
+				}
+			}
+		}
+		return b.bindClient(ctx, plan, instanceID, bindingID, details, target)
+	case PlanKindUser, PlanKindClientUser:
+		spaceID, orgID, err := b.bindingTarget(ctx, instanceID, details)
+		if err != nil {
+			return Binding{}, err
+		}
+		if err := b.checkOrg(ctx, plan.PlanRef, orgID, platformContext.OrganizationName); err != nil {
+			return Binding{}, err
+		}
+		var grants []roleGrant
+		if details.PredecessorBindingID != "" {
+			grants, err = b.predecessorGrants(ctx, plan, details.PredecessorBindingID, orgID, spaceID)
+		} else {
+			grants, err = b.requestedGrants(ctx, plan, details, orgID, spaceID)
+		}
+		if err != nil {
+			return Binding{}, err
+		}
+		if plan.Kind == PlanKindClientUser {
+			return b.bindClientUser(ctx, plan, bindingID, grants)
+		}
+		return b.bindUser(ctx, plan, bindingID, grants)
+	default:
+		return Binding{}, fmt.Errorf("Plan kind %s not supported", plan.Kind)
+	}
+}
+
+func (b *DeployerAccountBroker) bindClient(
+	ctx context.Context,
+	plan RegisteredPlan,
+	instanceID, bindingID string,
+	details BindDetails,
+	target clientTarget,
+) (Binding, error) {
 	password := b.generatePassword(b.config.PasswordLength)
 
-	switch details.ServiceID {
-	case clientAccountGUID:
-		opts, err := parseBindOptions(details)
+	var opts BindOptions
+	var err error
+	if details.PredecessorBindingID != "" {
+		opts, err = b.predecessorOptions(ctx, details.PredecessorBindingID)
+	} else {
+		opts, err = b.bindOptions(ctx, plan, instanceID, details.BindDetails)
+	}
+	if err != nil {
+		return Binding{}, err
+	}
+
+	appGUID := details.AppGUID
+	if appGUID == "" && details.BindResource != nil {
+		appGUID = details.BindResource.AppGuid
+	}
+	// Redirect URIs derived from the app's routes are on routes that CF has
+	// mapped to the app, which may be in another space if the instance is
+	// shared, so they are not checked against the instance's space
+	fromApp := len(opts.RedirectURI) == 0 && appGUID != "" && containsString(opts.grantTypes(plan), "authorization_code")
+	if fromApp {
+		if opts.RedirectURI, err = b.appRedirectURIs(ctx, appGUID); err != nil {
+			return Binding{}, err
+		}
+	}
+
+	allowedScopes, err := b.allowedScopes(ctx, plan, &target)
+	if err != nil {
+		return Binding{}, err
+	}
+	client, err := b.buildClient(plan, bindingID, password, opts, allowedScopes)
+	if err != nil {
+		return Binding{}, invalidParameters(err)
+	}
+	if err := checkGrantTypes(client); err != nil {
+		return Binding{}, invalidParameters(err)
+	}
+	if err := b.checkRedirectURIs(ctx, plan, client.RedirectURI, &target, !fromApp); err != nil {
+		return Binding{}, err
+	}
+
+	credentials := b.oidc.credentials()
+	credentials["client_id"] = bindingID
+	// Public clients authenticate with PKCE instead of their secret
+	if !client.AllowPublic {
+		credentials["client_secret"] = password
+	}
+	binding := Binding{Credentials: credentials}
+
+	_, err = b.uaaClient.CreateClient(ctx, client)
+	if err == nil {
+		return binding, nil
+	}
+	if !errors.Is(err, ErrUAAConflict) {
+		return Binding{}, err
+	}
+
+	// The platform retried a bind that already created the client
+	existing, err := b.uaaClient.GetClient(ctx, bindingID)
+	if err != nil {
+		return Binding{}, err
+	}
+	if !sameClient(existing, client) {
+		return Binding{}, brokerapi.ErrBindingAlreadyExists
+	}
+	if err := b.uaaClient.ChangeClientSecret(ctx, bindingID, password); err != nil {
+		return Binding{}, err
+	}
+
+	binding.AlreadyExists = true
+	return binding, nil
+}
+
+func (b *DeployerAccountBroker) bindUser(
+	ctx context.Context,
+	plan RegisteredPlan,
+	bindingID string,
+	grants []roleGrant,
+) (Binding, error) {
+	password := b.generatePassword(b.config.PasswordLength)
+
+	credentials := map[string]interface{}{
+		"username": bindingID,
+		"password": password,
+	}
+	if len(plan.RequestableRoles) > 0 {
+		credentials["roles"] = grantedSpaceRoles(grants)
+	}
+	binding := Binding{Credentials: credentials}
+
+	steps := b.bindSaga(ctx, bindingID)
+
+	user, err := b.provisionUser(ctx, bindingID, password)
+	if err != nil {
+		if !errors.Is(err, ErrUAAConflict) {
+			return Binding{}, err
+		}
+		// The platform retried a bind that already created the user
+		existing, err := b.existingUser(ctx, bindingID, grants)
 		if err != nil {
-			return brokerapi.Binding{}, err
+			return Binding{}, err
+		}
+		if err := b.uaaClient.ChangeUserPassword(ctx, existing.ID, password); err != nil {
+			return Binding{}, err
+		}
+		binding.AlreadyExists = true
+		return binding, nil
+	}
+	steps.Completed("uaa-user", func(ctx context.Context) error { return b.uaaClient.DeleteUser(ctx, user.ID) })
+
+	_, err = b.cfClient.CreateUser(ctx, user.ID)
+	if err != nil {
+		return Binding{}, steps.Rollback(err)
+	}
+	steps.Completed("cf-user", func(ctx context.Context) error { return b.cfClient.DeleteUser(ctx, user.ID) })
+
+	err = b.grantRoles(ctx, steps, grants, func(grant roleGrant) (*cf.Role, error) {
+		if grant.SpaceID != "" {
+			return b.associateSpaceRole(ctx, grant.SpaceID, user.UserName, grant.Role)
+		}
+		return b.associateOrgRole(ctx, grant.OrgID, user.UserName, grant.Role)
+	})
+	if err != nil {
+		return Binding{}, err
+	}
+
+	return binding, nil
+}
+
+// bindClientUser creates a UAA client that authenticates with client
+// credentials, registers it as a CF user and grants it roles.
+func (b *DeployerAccountBroker) bindClientUser(
+	ctx context.Context,
+	plan RegisteredPlan,
+	bindingID string,
+	grants []roleGrant,
+) (Binding, error) {
+	secret := b.generatePassword(b.config.PasswordLength)
+
+	credentials := map[string]interface{}{
+		"client_id":     bindingID,
+		"client_secret": secret,
+	}
+	if len(plan.RequestableRoles) > 0 {
+		credentials["roles"] = grantedSpaceRoles(grants)
+	}
+	binding := Binding{Credentials: credentials}
+
+	client := Client{
+		ID:                   bindingID,
+		ClientSecret:         secret,
+		AuthorizedGrantTypes: []string{"client_credentials"},
+		Authorities:          plan.Authorities,
+		AccessTokenValidity:  plan.AccessTokenValidity,
+	}
+	if client.AccessTokenValidity == 0 {
+		client.AccessTokenValidity = b.config.AccessTokenValidity
+	}
+
+	steps := b.bindSaga(ctx, bindingID)
+
+	_, err := b.uaaClient.CreateClient(ctx, client)
+	if err != nil {
+		if !errors.Is(err, ErrUAAConflict) {
+			return Binding{}, err
+		}
+		// The platform retried a bind that already created the client
+		if err := b.checkExistingRoles(ctx, bindingID, grants); err != nil {
+			return Binding{}, err
 		}
+		if err := b.uaaClient.ChangeClientSecret(ctx, bindingID, secret); err != nil {
+			return Binding{}, err
+		}
+		binding.AlreadyExists = true
+		return binding, nil
+	}
+	steps.Completed("uaa-client", func(ctx context.Context) error { return b.uaaClient.DeleteClient(ctx, bindingID) })
+
+	_, err = b.cfClient.CreateUser(ctx, bindingID)
+	if err != nil {
+		return Binding{}, steps.Rollback(err)
+	}
+	steps.Completed("cf-user", func(ctx context.Context) error { return b.cfClient.DeleteUser(ctx, bindingID) })
+
+	err = b.grantRoles(ctx, steps, grants, func(grant roleGrant) (*cf.Role, error) {
+		if grant.SpaceID != "" {
+			return b.cfClient.AssociateSpaceRoleByUserGuid(ctx, grant.SpaceID, bindingID, supportedSpaceRoles[grant.Role])
+		}
+		return b.cfClient.AssociateOrgRoleByUserGuid(ctx, grant.OrgID, bindingID, supportedOrgRoles[grant.Role])
+	})
+	if err != nil {
+		return Binding{}, err
+	}
+
+	return binding, nil
+}
+
+// bindSaga returns a saga for the steps of a bind. They are undone on a
+// background context, so that a bind cancelled by the platform is still
+// cleaned up.
+func (b *DeployerAccountBroker) bindSaga(ctx context.Context, bindingID string) *saga {
+	return newSaga(b.logger.Session("bind", lager.Data{"bindingID": bindingID}), func() (context.Context, context.CancelFunc) {
+		return b.backgroundContext(ctx)
+	})
+}
+
+// roleGrant is an org or space role of a service account.
+type roleGrant struct {
+	Role    string
+	OrgID   string
+	SpaceID string
+}
+
+// requestedGrants returns the plan's org roles and the space roles requested
+// by the bind parameters, in the instance's space and any others requested.
+func (b *DeployerAccountBroker) requestedGrants(ctx context.Context, plan RegisteredPlan, details BindDetails, orgID, spaceID string) ([]roleGrant, error) {
+	opts, err := parseUserBindOptions(plan, details.BindDetails)
+	if err != nil {
+		return nil, err
+	}
+	spaceIDs, err := b.orgSpaces(ctx, orgID, spaceID, opts.Spaces)
+	if err != nil {
+		return nil, err
+	}
 
-		if _, err := b.provisionClient(bindingID, password, opts); err != nil {
-			return brokerapi.Binding{}, err
+	grants := []roleGrant{}
+	for _, role := range plan.OrgRoles {
+		grants = append(grants, roleGrant{Role: role, OrgID: orgID})
+	}
+	for _, spaceID := range spaceIDs {
+		for _, role := range opts.spaceRoles(plan) {
+			grants = append(grants, roleGrant{Role: role, SpaceID: spaceID})
 		}
+	}
+	if err := b.checkRequesterRoles(ctx, details, orgID, spaceID, grants); err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
 
-		return brokerapi.Binding{
-			Credentials: map[string]string{
-				"client_id":     bindingID,
-				"client_secret": password,
-			},
-		}, nil
-	case userAccountGUID:
-		instance, err := b.cfClient.ServiceInstanceByGuid(instanceID)
+// spaceDeveloperRoles are the space roles that a space developer may grant,
+// as it can do all that they allow.
+var spaceDeveloperRoles = []string{"space_developer", "space_auditor", "space_supporter"}
+
+// checkRequesterRoles returns an OSB error unless the user who requested a
+// bind holds each of grants. Anyone who can create a service key in the
+// instance's space may bind, so without this check a key could grant roles
+// its creator does not hold. Such a user is already an org user and a space
+// developer in that space, so those roles are not looked up.
+func (b *DeployerAccountBroker) checkRequesterRoles(ctx context.Context, details BindDetails, orgID, spaceID string, grants []roleGrant) error {
+	wanted := []roleGrant{}
+	for _, grant := range grants {
+		if grant == (roleGrant{Role: "organization_user", OrgID: orgID}) ||
+			grant.SpaceID == spaceID && containsString(spaceDeveloperRoles, grant.Role) {
+			continue
+		}
+		wanted = append(wanted, grant)
+	}
+	if len(wanted) == 0 {
+		return nil
+	}
+	userID, err := details.OriginatingUserID()
+	if err != nil {
+		return err
+	}
+	if userID == "" {
+		return brokerapi.NewFailureResponse(
+			errors.New("Granting roles other than space_developer in the service instance's space requires the platform to identify the requesting user"),
+			http.StatusForbidden,
+			"requester-unknown",
+		)
+	}
+
+	orgRoles, spaceRoles := groupGrants(wanted)
+	for orgID, roles := range orgRoles {
+		held, err := b.cfClient.ListOrgRolesByUser(ctx, orgID, userID)
+		if err != nil {
+			return err
+		}
+		if !holdsRoles(held, roles) {
+			return requesterRolesNotHeld(roles, "org", orgID)
+		}
+	}
+	for spaceID, roles := range spaceRoles {
+		held, err := b.cfClient.ListSpaceRolesByUser(ctx, spaceID, userID)
 		if err != nil {
-			return brokerapi.Binding{}, err
+			return err
+		}
+		if holdsRoles(held, []string{"space_developer"}) {
+			roles = withoutStrings(roles, spaceDeveloperRoles)
 		}
+		if !holdsRoles(held, roles) {
+			return requesterRolesNotHeld(roles, "space", spaceID)
+		}
+	}
+	return nil
+}
 
-		space, err := b.cfClient.GetSpaceByGuid(instance.Relationships.Space.Data.GUID)
+func requesterRolesNotHeld(roles []string, kind, guid string) error {
+	return brokerapi.NewFailureResponse(
+		fmt.Errorf("Requesting user does not hold roles %s in %s %s", strings.Join(roles, ", "), kind, guid),
+		http.StatusForbidden,
+		"requester-roles-not-held",
+	)
+}
+
+// checkPredecessor returns an OSB error unless predecessorID is a binding of
+// the service instance, so that rotating a binding cannot copy another
+// instance's client or roles.
+func (b *DeployerAccountBroker) checkPredecessor(ctx context.Context, instanceID, predecessorID string) error {
+	bindings, err := b.cfClient.ServiceCredentialBindingsByInstanceGuid(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+	for _, binding := range bindings {
+		if binding.GUID == predecessorID {
+			return nil
+		}
+	}
+	return predecessorNotFound(predecessorID)
+}
+
+// predecessorOptions returns the configuration of the client of the binding
+// that a rotated binding replaces. The options are checked against the plan
+// and org like those given when binding.
+func (b *DeployerAccountBroker) predecessorOptions(ctx context.Context, predecessorID string) (BindOptions, error) {
+	predecessor, err := b.uaaClient.GetClient(ctx, predecessorID)
+	if errors.Is(err, ErrUAANotFound) {
+		return BindOptions{}, predecessorNotFound(predecessorID)
+	}
+	if err != nil {
+		return BindOptions{}, err
+	}
+	predecessor = predecessor.withoutPlaceholders()
+	return BindOptions{
+		RedirectURI: predecessor.RedirectURI,
+		Scopes:      predecessor.Scope,
+		AllowPublic: &predecessor.AllowPublic,
+		GrantTypes:  predecessor.AuthorizedGrantTypes,
+		Authorities: predecessor.Authorities,
+	}, nil
+}
+
+// predecessorGrants returns the roles held by the service account of the
+// binding that a rotated binding replaces. They must be in the instance's org.
+func (b *DeployerAccountBroker) predecessorGrants(ctx context.Context, plan RegisteredPlan, predecessorID, orgID, spaceID string) ([]roleGrant, error) {
+	userGUID := predecessorID
+	var err error
+	if plan.Kind == PlanKindClientUser {
+		_, err = b.uaaClient.GetClient(ctx, predecessorID)
+	} else {
+		var user User
+		user, err = b.uaaClient.GetUser(ctx, predecessorID)
+		userGUID = user.ID
+	}
+	if errors.Is(err, ErrUAANotFound) {
+		return nil, predecessorNotFound(predecessorID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := b.cfClient.ListRolesByUser(ctx, userGUID)
+	if err != nil {
+		return nil, err
+	}
+
+	grants := []roleGrant{}
+	spaceIDs := []string{}
+	for _, role := range roles {
+		switch {
+		case role.Relationships.Space.Data != nil:
+			grants = append(grants, roleGrant{Role: role.Type, SpaceID: role.Relationships.Space.Data.GUID})
+			if role.Relationships.Space.Data.GUID != spaceID {
+				spaceIDs = append(spaceIDs, role.Relationships.Space.Data.GUID)
+			}
+		case role.Relationships.Org.Data != nil:
+			if role.Relationships.Org.Data.GUID != orgID {
+				return nil, invalidParameters(fmt.Errorf("Predecessor binding %s is not in the service instance's organization", predecessorID))
+			}
+			grants = append(grants, roleGrant{Role: role.Type, OrgID: orgID})
+		}
+	}
+	if _, err := b.orgSpaces(ctx, orgID, spaceID, spaceIDs); err != nil {
+		return nil, err
+	}
+	// CF grants space roles only to users of the org
+	sort.SliceStable(grants, func(i, j int) bool {
+		return grants[i].SpaceID == "" && grants[j].SpaceID != ""
+	})
+	return grants, nil
+}
+
+func predecessorNotFound(predecessorID string) error {
+	return brokerapi.NewFailureResponse(
+		fmt.Errorf("Predecessor binding %s not found", predecessorID),
+		http.StatusBadRequest,
+		"predecessor-not-found",
+	)
+}
+
+// grantRoles grants each role with grant, recording a step that removes it.
+func (b *DeployerAccountBroker) grantRoles(ctx context.Context, steps *saga, grants []roleGrant, grant func(roleGrant) (*cf.Role, error)) error {
+	for _, g := range grants {
+		role, err := grant(g)
 		if err != nil {
-			return brokerapi.Binding{}, err
+			return steps.Rollback(err)
+		}
+		name := g.Role
+		if g.SpaceID != "" {
+			name += "/" + g.SpaceID
+		}
+		steps.Completed(name, func(ctx context.Context) error { return b.cfClient.DeleteRole(ctx, role.GUID) })
+	}
+	return nil
+}
+
+// grantedSpaceRoles returns the distinct space roles among grants.
+func grantedSpaceRoles(grants []roleGrant) []string {
+	roles := []string{}
+	for _, grant := range grants {
+		if grant.SpaceID != "" && !containsString(roles, grant.Role) {
+			roles = append(roles, grant.Role)
 		}
+	}
+	return roles
+}
+
+// bindingTarget returns the space and org of the service instance, taken from
+// the platform's context when present and otherwise looked up through the CF
+// API. The space in bind_resource is the app's, which differs from the
+// instance's when the instance is shared with other spaces.
+func (b *DeployerAccountBroker) bindingTarget(ctx context.Context, instanceID string, details BindDetails) (string, string, error) {
+	platformContext, err := details.PlatformContext()
+	if err != nil {
+		return "", "", err
+	}
+
+	spaceID := platformContext.SpaceGUID
+	if spaceID != "" && platformContext.OrganizationGUID != "" {
+		return spaceID, platformContext.OrganizationGUID, nil
+	}
+
+	if spaceID == "" {
+		instance, err := b.cfClient.ServiceInstanceByGuid(ctx, instanceID)
+		if err != nil {
+			return "", "", err
+		}
+		spaceID = instance.Relationships.Space.Data.GUID
+	}
+
+	space, err := b.cfClient.GetSpaceByGuid(ctx, spaceID)
+	if err != nil {
+		return "", "", err
+	}
+
+	return spaceID, space.Relationships.Organization.Data.GUID, nil
+}
+
+// orgSpaces returns the GUIDs of spaceID and of the spaces named by GUID or
+// name in spaces, or an OSB error if any of them is not in the org.
+func (b *DeployerAccountBroker) orgSpaces(ctx context.Context, orgID, spaceID string, spaces []string) ([]string, error) {
+	spaceIDs := []string{spaceID}
+	seen := map[string]bool{spaceID: true}
+	add := func(guid string) {
+		if !seen[guid] {
+			seen[guid] = true
+			spaceIDs = append(spaceIDs, guid)
+		}
+	}
+
+	names := []string{}
+	for _, space := range spaces {
+		if !guidPattern.MatchString(space) {
+			names = append(names, space)
+			continue
+		}
+		if seen[space] {
+			continue
+		}
+		found, err := b.cfClient.GetSpaceByGuid(ctx, space)
+		if err != nil {
+			return nil, err
+		}
+		if found.Relationships.Organization.Data.GUID != orgID {
+			return nil, invalidParameters(fmt.Errorf("Space %s is not in the service instance's organization", space))
+		}
+		add(found.GUID)
+	}
+	if len(names) == 0 {
+		return spaceIDs, nil
+	}
+
+	found, err := b.cfClient.ListSpacesByName(ctx, orgID, names)
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]string{}
+	for _, space := range found {
+		byName[space.Name] = space.GUID
+	}
+	for _, name := range names {
+		guid, ok := byName[name]
+		if !ok {
+			return nil, invalidParameters(fmt.Errorf("Space %s not found in the service instance's organization", name))
+		}
+		add(guid)
+	}
+	return spaceIDs, nil
+}
+
+// instanceDefaults returns the bind defaults stored when a service instance
+// was provisioned.
+func (b *DeployerAccountBroker) instanceDefaults(ctx context.Context, instanceID string) (BindOptions, error) {
+	client, err := b.uaaClient.GetClient(ctx, instanceID)
+	if errors.Is(err, ErrUAANotFound) {
+		return BindOptions{}, nil
+	}
+	if err != nil {
+		return BindOptions{}, err
+	}
+	// Instances from before credential management was moved to bind have a
+	// client without defaults
+	if client.BindDefaults == nil {
+		return BindOptions{}, nil
+	}
+	return *client.BindDefaults, nil
+}
+
+// plan returns a plan from the registry, withdrawn or not, or an OSB error if
+// the catalog does not list it.
+func (b *DeployerAccountBroker) plan(serviceID, planID string) (RegisteredPlan, error) {
+	plan, ok := b.plans.Plan(serviceID, planID)
+	if !ok {
+		return RegisteredPlan{}, brokerapi.NewFailureResponse(
+			fmt.Errorf("Plan %s of service %s not found in catalog", planID, serviceID),
+			http.StatusBadRequest, "unknown-plan",
+		)
+	}
+	return plan, nil
+}
+
+// offeredPlan returns a plan from the registry, or an OSB error if the catalog
+// does not offer it.
+func (b *DeployerAccountBroker) offeredPlan(serviceID, planID string) (RegisteredPlan, error) {
+	plan, err := b.plan(serviceID, planID)
+	if err == nil && plan.Withdrawn {
+		return RegisteredPlan{}, brokerapi.NewFailureResponse(
+			fmt.Errorf("Plan %s of service %s is not offered", plan.PlanName, plan.ServiceName),
+			http.StatusBadRequest, "plan-not-offered",
+		)
+	}
+	return plan, err
+}
 
-		user, err := b.provisionUser(bindingID, password)
+// clientTarget is the space and org that a client is provisioned or bound
+// in. It is left empty when nothing about the client depends on it.
+type clientTarget struct {
+	SpaceID string
+	OrgID   string
+	OrgName string
+}
+
+// clientNeedsTarget reports whether the scopes or redirect URIs that plan
+// allows depend on where a client is bound.
+func (b *DeployerAccountBroker) clientNeedsTarget(plan RegisteredPlan) bool {
+	return len(plan.OrgScopes) > 0 ||
+		b.config.CheckRedirectRoutes ||
+		len(b.config.WildcardRedirectOrgs.For(plan.PlanRef)) > 0
+}
+
+// orgName returns the name of the target's org, looking it up if the
+// platform did not send it.
+func (b *DeployerAccountBroker) orgName(ctx context.Context, target *clientTarget) (string, error) {
+	if target.OrgName == "" {
+		org, err := b.cfClient.GetOrganizationByGuid(ctx, target.OrgID)
 		if err != nil {
-			return brokerapi.Binding{}, err
+			return "", err
+		}
+		target.OrgName = org.Name
+	}
+	return target.OrgName, nil
+}
+
+// allowedScopes returns the scopes that plan allows clients in the target's
+// org to be granted.
+func (b *DeployerAccountBroker) allowedScopes(ctx context.Context, plan RegisteredPlan, target *clientTarget) ([]string, error) {
+	orgName := target.OrgName
+	if plan.orgScopesNeedName() {
+		var err error
+		if orgName, err = b.orgName(ctx, target); err != nil {
+			return nil, err
 		}
-		_, err = b.cfClient.CreateUser(user.ID)
+	}
+	return plan.allowedScopes(target.OrgID, orgName), nil
+}
+
+// checkOrg returns an OSB error if the org policy refuses the org for plan.
+// The org's name is looked up only when a rule needs it and the platform did
+// not send it.
+func (b *DeployerAccountBroker) checkOrg(ctx context.Context, plan PlanRef, orgID, orgName string) error {
+	policy := b.config.OrgPolicy()
+	deny, allow := policy.Rules(plan)
+	if orgName == "" && (deny.NeedsName() || allow.NeedsName()) {
+		org, err := b.cfClient.GetOrganizationByGuid(ctx, orgID)
 		if err != nil {
-			return brokerapi.Binding{}, err
+			return err
 		}
+		orgName = org.Name
+	}
+
+	if err := policy.Check(plan, orgID, orgName); err != nil {
+		b.logger.Info("org-not-permitted", requestData(ctx, lager.Data{
+			"orgID":     orgID,
+			"serviceID": plan.ServiceID,
+			"planID":    plan.PlanID,
+		}))
+		return err
+	}
+	return nil
+}
+
+// existingUser returns the user created for bindingID, or
+// brokerapi.ErrBindingAlreadyExists if the user lacks any of grants.
+func (b *DeployerAccountBroker) existingUser(ctx context.Context, bindingID string, grants []roleGrant) (User, error) {
+	user, err := b.uaaClient.GetUser(ctx, bindingID)
+	if err != nil {
+		return User{}, err
+	}
+	if err := b.checkExistingRoles(ctx, user.ID, grants); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
 
-		_, err = b.cfClient.AssociateOrgUserByUsername(space.Relationships.Organization.Data.GUID, user.UserName)
+// checkExistingRoles returns brokerapi.ErrBindingAlreadyExists if the CF user
+// lacks any of grants.
+func (b *DeployerAccountBroker) checkExistingRoles(ctx context.Context, userGUID string, grants []roleGrant) error {
+	orgRoles, spaceRoles := groupGrants(grants)
+	for orgID, wanted := range orgRoles {
+		roles, err := b.cfClient.ListOrgRolesByUser(ctx, orgID, userGUID)
 		if err != nil {
-			return brokerapi.Binding{}, err
+			return err
+		}
+		if !holdsRoles(roles, wanted) {
+			return brokerapi.ErrBindingAlreadyExists
 		}
+	}
 
-		switch details.PlanID {
-		case deployerGUID:
-			_, err = b.cfClient.AssociateSpaceDeveloperByUsername(instance.Relationships.Space.Data.GUID, user.UserName)
-			if err != nil {
-				return brokerapi.Binding{}, err
-			}
-		case auditorGUID:
-			_, err = b.cfClient.AssociateSpaceAuditorByUsername(instance.Relationships.Space.Data.GUID, user.UserName)
-			if err != nil {
-				return brokerapi.Binding{}, err
-			}
+	for spaceID, wanted := range spaceRoles {
+		roles, err := b.cfClient.ListSpaceRolesByUser(ctx, spaceID, userGUID)
+		if err != nil {
+			return err
+		}
+		if !holdsRoles(roles, wanted) {
+			return brokerapi.ErrBindingAlreadyExists
 		}
+	}
 
-		return brokerapi.Binding{
-			Credentials: map[string]string{
-				"username": bindingID,
-				"password": password,
-			},
-		}, nil
-	default:
-		return brokerapi.Binding{}, fmt.Errorf("Service ID %s not found", details.ServiceID)
+	return nil
+}
+
+// groupGrants returns the roles of grants by org and by space.
+func groupGrants(grants []roleGrant) (orgRoles, spaceRoles map[string][]string) {
+	orgRoles = map[string][]string{}
+	spaceRoles = map[string][]string{}
+	for _, grant := range grants {
+		if grant.SpaceID != "" {
+			spaceRoles[grant.SpaceID] = append(spaceRoles[grant.SpaceID], grant.Role)
+		} else {
+			orgRoles[grant.OrgID] = append(orgRoles[grant.OrgID], grant.Role)
+		}
 	}
+	return orgRoles, spaceRoles
+}
 
-	return brokerapi.Binding{}, nil
+func holdsRoles(roles []*cf.Role, wanted []string) bool {
+	held := map[string]bool{}
+	for _, r := range roles {
+		held[r.Type] = true
+	}
+	for _, role := range wanted {
+		if !held[role] {
+			return false
+		}
+	}
+	return true
 }
 
 func (b *DeployerAccountBroker) Unbind(
-	context context.Context,
+	ctx context.Context,
 	instanceID,
 	bindingID string,
 	details brokerapi.UnbindDetails,
 ) error {
-	switch details.ServiceID {
-	case clientAccountGUID:
-		err := b.deleteClient(bindingID)
+	_, err := b.UnbindAsync(ctx, instanceID, bindingID, details, false)
+	return err
+}
+
+// UnbindAsync removes service accounts in the background when the platform
+// accepts incomplete responses; all other unbinds complete synchronously.
+func (b *DeployerAccountBroker) UnbindAsync(
+	ctx context.Context,
+	instanceID,
+	bindingID string,
+	details brokerapi.UnbindDetails,
+	asyncAllowed bool,
+) (UnbindSpec, error) {
+	plan, err := b.plan(details.ServiceID, details.PlanID)
+	if err != nil {
+		return UnbindSpec{}, err
+	}
+	if !asyncAllowed || !plan.Kind.cfUser() {
+		return UnbindSpec{}, b.unbind(ctx, plan, bindingID)
+	}
+
+	op, started, err := b.operations.Start(UnbindOperation, instanceID, bindingID)
+	if err != nil {
+		return UnbindSpec{}, err
+	}
+	if started {
+		ctx, cancel := b.backgroundContext(ctx)
+		go func() {
+			defer cancel()
+			err := b.unbind(ctx, plan, bindingID)
+			if err != nil {
+				b.logger.Error("async-unbind", err, requestData(ctx, lager.Data{"bindingID": bindingID, "operation": op.ID}))
+			}
+			b.operations.Finish(op.ID, Binding{}, err)
+		}()
+	}
+
+	return UnbindSpec{IsAsync: true, OperationData: op.ID}, nil
+}
+
+func (b *DeployerAccountBroker) unbind(
+	ctx context.Context,
+	plan RegisteredPlan,
+	bindingID string,
+) error {
+	if b.operations != nil {
+		b.operations.Forget(bindingID)
+	}
+	return b.deleteCredentials(ctx, plan, bindingID)
+}
+
+// deleteCredentials removes the UAA user or client of a binding, along with
+// the CF user and roles of service accounts. Credentials that no longer exist
+// are not an error.
+func (b *DeployerAccountBroker) deleteCredentials(
+	ctx context.Context,
+	plan RegisteredPlan,
+	bindingID string,
+) error {
+	switch plan.Kind {
+	case PlanKindClient:
+		err := b.deleteClient(ctx, bindingID)
+		if err != nil {
+			return err
+		}
+	case PlanKindUser:
+		user, err := b.uaaClient.GetUser(ctx, bindingID)
+		if err != nil {
+			if errors.Is(err, ErrUAANotFound) {
+				return nil
+			}
+			return err
+		}
+
+		err = b.deleteRoles(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = b.cfClient.DeleteUser(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = b.uaaClient.DeleteUser(ctx, user.ID)
 		if err != nil {
 			return err
 		}
-	case userAccountGUID:
-		user, err := b.uaaClient.GetUser(bindingID)
+	case PlanKindClientUser:
+		_, err := b.uaaClient.GetClient(ctx, bindingID)
 		if err != nil {
-			if strings.Contains(err.Error(), "got 0") {
+			if errors.Is(err, ErrUAANotFound) {
 				return nil
 			}
 			return err
 		}
 
-		err = b.cfClient.DeleteUser(user.ID)
+		err = b.deleteRoles(ctx, bindingID)
 		if err != nil {
 			return err
 		}
 
-		err = b.uaaClient.DeleteUser(user.ID)
+		err = b.cfClient.DeleteUser(ctx, bindingID)
+		if err != nil {
+			return err
+		}
+
+		err = b.deleteClient(ctx, bindingID)
 		if err != nil {
 			return err
 		}
 	default:
-		return fmt.Errorf("Service ID %s not found", details.ServiceID)
+		return fmt.Errorf("Plan kind %s not supported", plan.Kind)
 	}
 
 	return nil
 }
 
-func (b *DeployerAccountBroker) Update(context context.Context, instanceID string, details brokerapi.UpdateDetails, asyncAllowed bool) (brokerapi.UpdateServiceSpec, error) {
-	return brokerapi.UpdateServiceSpec{}, errors.New("Broker does not support update")
+// deleteRoles removes every role held by a service account, in each space it
+// was granted and then in the org. CF refuses to remove an org role while the
+// user still holds space roles in the org, so each removal is waited for.
+func (b *DeployerAccountBroker) deleteRoles(ctx context.Context, userID string) error {
+	roles, err := b.cfClient.ListRolesByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(roles, func(i, j int) bool {
+		return roles[i].Relationships.Space.Data != nil && roles[j].Relationships.Space.Data == nil
+	})
+	for _, role := range roles {
+		if err := b.cfClient.DeleteRole(ctx, role.GUID); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (b *DeployerAccountBroker) LastOperation(context context.Context, instanceID, operationData string) (brokerapi.LastOperation, error) {
-	return brokerapi.LastOperation{}, errors.New("Broker does not support last operation")
+// Update switches a service account between plans by swapping the space roles
+// of the user behind each of the instance's bindings, in every space where the
+// binding granted them. Plans must differ only in their space roles.
+func (b *DeployerAccountBroker) Update(ctx context.Context, instanceID string, details brokerapi.UpdateDetails, asyncAllowed bool) (brokerapi.UpdateServiceSpec, error) {
+	planID := details.PlanID
+	if planID == "" {
+		planID = details.PreviousValues.PlanID
+	}
+	if planID == "" {
+		return brokerapi.UpdateServiceSpec{}, nil
+	}
+	newPlan, err := b.offeredPlan(details.ServiceID, planID)
+	if err != nil {
+		return brokerapi.UpdateServiceSpec{}, err
+	}
+	if err := newPlan.UpdateSchema.Validate(details.RawParameters); err != nil {
+		return brokerapi.UpdateServiceSpec{}, err
+	}
+
+	if planID == details.PreviousValues.PlanID {
+		return brokerapi.UpdateServiceSpec{}, nil
+	}
+
+	spaceID := details.PreviousValues.SpaceID
+	if spaceID == "" {
+		instance, err := b.cfClient.ServiceInstanceByGuid(ctx, instanceID)
+		if err != nil {
+			return brokerapi.UpdateServiceSpec{}, err
+		}
+		spaceID = instance.Relationships.Space.Data.GUID
+	}
+	orgID := details.PreviousValues.OrgID
+	if orgID == "" {
+		space, err := b.cfClient.GetSpaceByGuid(ctx, spaceID)
+		if err != nil {
+			return brokerapi.UpdateServiceSpec{}, err
+		}
+		orgID = space.Relationships.Organization.Data.GUID
+	}
+	// The org must be allowed the new plan as when provisioning it
+	if err := b.checkOrg(ctx, newPlan.PlanRef, orgID, ""); err != nil {
+		return brokerapi.UpdateServiceSpec{}, err
+	}
+
+	oldPlan, ok := b.plans.Plan(details.ServiceID, details.PreviousValues.PlanID)
+	// Roles requested when binding do not carry over to another plan
+	if !ok || newPlan.Kind != PlanKindUser || oldPlan.Kind != PlanKindUser || !sameStrings(newPlan.OrgRoles, oldPlan.OrgRoles) ||
+		len(newPlan.RequestableRoles) > 0 || len(oldPlan.RequestableRoles) > 0 {
+		return brokerapi.UpdateServiceSpec{}, brokerapi.ErrPlanChangeNotSupported
+	}
+
+	newRoles := map[string]bool{}
+	for _, role := range newPlan.SpaceRoles {
+		newRoles[role] = true
+	}
+	oldRoles := map[string]bool{}
+	for _, role := range oldPlan.SpaceRoles {
+		if !newRoles[role] {
+			oldRoles[role] = true
+		}
+	}
+
+	bindings, err := b.cfClient.ServiceCredentialBindingsByInstanceGuid(ctx, instanceID)
+	if err != nil {
+		return brokerapi.UpdateServiceSpec{}, err
+	}
+
+	for _, binding := range bindings {
+		user, err := b.uaaClient.GetUser(ctx, binding.GUID)
+		if err != nil {
+			// Bindings from before credential management was moved to bind have no user
+			if errors.Is(err, ErrUAANotFound) {
+				continue
+			}
+			return brokerapi.UpdateServiceSpec{}, err
+		}
+
+		roles, err := b.cfClient.ListRolesByUser(ctx, user.ID)
+		if err != nil {
+			return brokerapi.UpdateServiceSpec{}, err
+		}
+		// The binding may have granted roles in other spaces of the org too
+		spaceIDs := []string{spaceID}
+		held := map[roleGrant]bool{}
+		for _, role := range roles {
+			if role.Relationships.Space.Data == nil {
+				continue
+			}
+			held[roleGrant{Role: role.Type, SpaceID: role.Relationships.Space.Data.GUID}] = true
+			if !containsString(spaceIDs, role.Relationships.Space.Data.GUID) {
+				spaceIDs = append(spaceIDs, role.Relationships.Space.Data.GUID)
+			}
+		}
+
+		for _, spaceID := range spaceIDs {
+			for _, role := range newPlan.SpaceRoles {
+				// CF refuses to grant a role twice, as when retrying an update
+				if held[roleGrant{Role: role, SpaceID: spaceID}] {
+					continue
+				}
+				if _, err := b.associateSpaceRole(ctx, spaceID, user.UserName, role); err != nil {
+					return brokerapi.UpdateServiceSpec{}, err
+				}
+			}
+		}
+		for _, role := range roles {
+			if role.Relationships.Space.Data == nil || !oldRoles[role.Type] {
+				continue
+			}
+			if err := b.cfClient.DeleteRole(ctx, role.GUID); err != nil {
+				return brokerapi.UpdateServiceSpec{}, err
+			}
+		}
+	}
+
+	return brokerapi.UpdateServiceSpec{}, nil
+}
+
+func (b *DeployerAccountBroker) LastOperation(ctx context.Context, instanceID, operationData string) (brokerapi.LastOperation, error) {
+	op, ok := b.operations.Get(operationData)
+	if !ok || op.InstanceID != instanceID {
+		return unknownOperation(operationData), nil
+	}
+
+	return brokerapi.LastOperation{State: op.State, Description: op.Description}, nil
+}
+
+func (b *DeployerAccountBroker) LastBindingOperation(ctx context.Context, instanceID, bindingID, operationData string) (brokerapi.LastOperation, error) {
+	op, ok := b.operations.Get(operationData)
+	if !ok || op.InstanceID != instanceID || op.BindingID != bindingID {
+		return unknownOperation(operationData), nil
+	}
+
+	return brokerapi.LastOperation{State: op.State, Description: op.Description}, nil
+}
+
+// GetBinding returns the credentials of a binding created asynchronously.
+func (b *DeployerAccountBroker) GetBinding(ctx context.Context, instanceID, bindingID string) (Binding, error) {
+	binding, ok := b.operations.Binding(bindingID)
+	if !ok {
+		return Binding{}, brokerapi.NewFailureResponse(
+			fmt.Errorf("Binding %s not found", bindingID), http.StatusNotFound, "get-binding",
+		)
+	}
+
+	return binding, nil
 }
 
-func (b *DeployerAccountBroker) provisionClient(
+// backgroundContext returns a context for work that outlives the request in
+// ctx. It keeps the request's values but not its cancellation, and is bounded
+// by the configured async operation timeout instead.
+func (b *DeployerAccountBroker) backgroundContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx = context.WithoutCancel(ctx)
+	if b.config.AsyncOperationTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, b.config.AsyncOperationTimeout)
+}
+
+// Operations are only tracked in memory, so an unknown operation most likely
+// belonged to a previous run of the broker.
+func unknownOperation(operationData string) brokerapi.LastOperation {
+	return brokerapi.LastOperation{
+		State:       brokerapi.Failed,
+		Description: fmt.Sprintf("Operation %s not found", operationData),
+	}
+}
+
+// buildClient returns the UAA client requested by opts, or an error if opts
+// asks for anything that plan does not permit or for scopes not in
+// allowedScopes.
+func (b *DeployerAccountBroker) buildClient(
+	plan RegisteredPlan,
 	clientID,
 	clientSecret string,
 	opts BindOptions,
+	allowedScopes []string,
 ) (Client, error) {
 	var scopes = opts.Scopes
 	if len(opts.Scopes) == 0 {
-		scopes = defaultScopes
+		scopes = plan.DefaultScopes
 	}
 	forbiddenScopes := []string{}
 	for _, scope := range scopes {
-		if _, ok := allowedScopes[scope]; !ok {
+		if !containsString(allowedScopes, scope) {
 			forbiddenScopes = append(forbiddenScopes, scope)
 		}
 	}
@@ -262,37 +1484,107 @@ func (b *DeployerAccountBroker) provisionClient(
 		return Client{}, fmt.Errorf("Scope(s) not permitted: %s", strings.Join(forbiddenScopes, ", "))
 	}
 
+	grantTypes := opts.grantTypes(plan)
+	forbiddenGrantTypes := []string{}
+	for _, grantType := range grantTypes {
+		if !containsString(plan.GrantTypes, grantType) && !containsString(plan.RequestableGrantTypes, grantType) {
+			forbiddenGrantTypes = append(forbiddenGrantTypes, grantType)
+		}
+	}
+	if len(forbiddenGrantTypes) > 0 {
+		return Client{}, fmt.Errorf("Grant type(s) not permitted: %s", strings.Join(forbiddenGrantTypes, ", "))
+	}
+
+	forbiddenAuthorities := []string{}
+	for _, authority := range opts.Authorities {
+		if !containsString(plan.RequestableAuthorities, authority) {
+			forbiddenAuthorities = append(forbiddenAuthorities, authority)
+		}
+	}
+	if len(forbiddenAuthorities) > 0 {
+		return Client{}, fmt.Errorf("Authorities not permitted: %s", strings.Join(forbiddenAuthorities, ", "))
+	}
+
 	client := Client{
 		ID:                   clientID,
-		AuthorizedGrantTypes: []string{"authorization_code", "refresh_token"},
+		AuthorizedGrantTypes: grantTypes,
 		Scope:                scopes,
+		Authorities:          opts.Authorities,
 		RedirectURI:          opts.RedirectURI,
 		ClientSecret:         clientSecret,
-		AccessTokenValidity:  b.config.AccessTokenValidity,
-		RefreshTokenValidity: b.config.RefreshTokenValidity,
+		AccessTokenValidity:  plan.AccessTokenValidity,
+		RefreshTokenValidity: plan.RefreshTokenValidity,
+	}
+	if client.AccessTokenValidity == 0 {
+		client.AccessTokenValidity = b.config.AccessTokenValidity
+	}
+	if client.RefreshTokenValidity == 0 {
+		client.RefreshTokenValidity = b.config.RefreshTokenValidity
 	}
 
 	if opts.AllowPublic != nil {
 		client.AllowPublic = *opts.AllowPublic
 	}
 
-	return b.uaaClient.CreateClient(client)
+	return client, nil
+}
+
+// checkGrantTypes returns an error if client breaks the rules of any of its
+// grant types. Users are redirected back to authorization code clients, and
+// only those may be public. Only client credentials clients act on their own
+// authority, and refresh tokens are only issued for authorization codes.
+func checkGrantTypes(client Client) error {
+	authCode := containsString(client.AuthorizedGrantTypes, "authorization_code")
+	clientCredentials := containsString(client.AuthorizedGrantTypes, "client_credentials")
+
+	switch {
+	case authCode && len(client.RedirectURI) == 0:
+		return errors.New(`must pass field "redirect_uri"`)
+	case !authCode && len(client.RedirectURI) > 0:
+		return errors.New(`Field "redirect_uri" requires grant type authorization_code`)
+	case !authCode && client.AllowPublic:
+		return errors.New(`Field "allowpublic" requires grant type authorization_code`)
+	case !clientCredentials && len(client.Authorities) > 0:
+		return errors.New(`Field "authorities" requires grant type client_credentials`)
+	case clientCredentials && client.AllowPublic:
+		return errors.New("Public clients may not use grant type client_credentials")
+	case !authCode && containsString(client.AuthorizedGrantTypes, "refresh_token"):
+		return errors.New("Grant type refresh_token requires grant type authorization_code")
+	}
+	return nil
+}
+
+// sameClient reports whether an existing client was created from the same
+// request as requested. Secrets are not compared, nor the placeholder that
+// UAA reports for a client created without scopes or authorities. requested
+// already holds the plan's default scopes.
+func sameClient(existing, requested Client) bool {
+	existing = existing.withoutPlaceholders()
+	requested = requested.withoutPlaceholders()
+	return sameStrings(existing.AuthorizedGrantTypes, requested.AuthorizedGrantTypes) &&
+		sameStrings(existing.Scope, requested.Scope) &&
+		sameStrings(existing.RedirectURI, requested.RedirectURI) &&
+		sameStrings(existing.Authorities, requested.Authorities) &&
+		existing.AllowPublic == requested.AllowPublic &&
+		existing.AccessTokenValidity == requested.AccessTokenValidity &&
+		existing.RefreshTokenValidity == requested.RefreshTokenValidity
 }
 
 func (b *DeployerAccountBroker) deleteClient(
+	ctx context.Context,
 	clientID string,
 ) error {
-	err := b.uaaClient.DeleteClient(clientID)
+	err := b.uaaClient.DeleteClient(ctx, clientID)
 
 	// Allow 404 responses on deletion
-	if err != nil && strings.Contains(err.Error(), "404") {
+	if errors.Is(err, ErrUAANotFound) {
 		return nil
 	}
 
 	return err
 }
 
-func (b *DeployerAccountBroker) provisionUser(userID, password string) (User, error) {
+func (b *DeployerAccountBroker) provisionUser(ctx context.Context, userID, password string) (User, error) {
 	user := User{
 		UserName: userID,
 		Password: password,
@@ -302,5 +1594,33 @@ func (b *DeployerAccountBroker) provisionUser(userID, password string) (User, er
 		}},
 	}
 
-	return b.uaaClient.CreateUser(user)
+	return b.uaaClient.CreateUser(ctx, user)
+}
+
+func (b *DeployerAccountBroker) associateOrgRole(ctx context.Context, orgID, userName, role string) (*cf.Role, error) {
+	switch role {
+	case cf.OrganizationRoleUser.String():
+		return b.cfClient.AssociateOrgUserByUsername(ctx, orgID, userName)
+	case cf.OrganizationRoleAuditor.String():
+		return b.cfClient.AssociateOrgAuditorByUsername(ctx, orgID, userName)
+	case cf.OrganizationRoleManager.String():
+		return b.cfClient.AssociateOrgManagerByUsername(ctx, orgID, userName)
+	default:
+		return nil, fmt.Errorf("Org role %s not supported", role)
+	}
+}
+
+func (b *DeployerAccountBroker) associateSpaceRole(ctx context.Context, spaceID, userName, role string) (*cf.Role, error) {
+	switch role {
+	case cf.SpaceRoleDeveloper.String():
+		return b.cfClient.AssociateSpaceDeveloperByUsername(ctx, spaceID, userName)
+	case cf.SpaceRoleAuditor.String():
+		return b.cfClient.AssociateSpaceAuditorByUsername(ctx, spaceID, userName)
+	case cf.SpaceRoleManager.String():
+		return b.cfClient.AssociateSpaceManagerByUsername(ctx, spaceID, userName)
+	case cf.SpaceRoleSupporter.String():
+		return b.cfClient.AssociateSpaceSupporterByUsername(ctx, spaceID, userName)
+	default:
+		return nil, fmt.Errorf("Space role %s not supported", role)
+	}
 }
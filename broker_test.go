@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
 
 	"code.cloudfoundry.org/lager/lagertest"
 	cf "github.com/cloudfoundry/go-cfclient/v3/resource"
@@ -15,6 +18,42 @@ import (
 	"github.com/cloud-gov/uaa-credentials-broker/mocks"
 )
 
+// Service and plan IDs from config.json.
+var (
+	clientAccountGUID  = "6b508bb8-2af7-4a75-9efd-7b76a01d705d"
+	userAccountGUID    = "964bd86d-72fa-4852-957f-e4cd802de34b"
+	oauthClientGUID    = "e6fd8aaa-b5ba-4b19-b52e-44c18ab8ca1d"
+	deployerGUID       = "074e652b-b77b-4ac3-8d5b-52144486b1a3"
+	auditorGUID        = "dc3a6d48-9622-434a-b418-1d920193b575"
+	spaceManagerGUID   = "5e0c8f1a-7b3d-4a92-b6e4-0d9c2f7a18b3"
+	spaceSupporterGUID = "a43b7d29-c61e-4f08-9e5a-8b2d4c0f6e17"
+	spaceRolesGUID     = "d5a9e3c1-0f7b-4b26-8e4d-3c1a6f9b2e70"
+	deployerClientGUID = "8f4c2a6d-9e1b-4d73-a5c0-2b7e9f3d6a18"
+	orgAuditorGUID     = "2f2f5a6e-8d7b-4c47-9a1e-5c3d9e0b6f41"
+	orgManagerGUID     = "b8d0c7e3-41a9-4f5e-8c62-7a9e1d3f0c25"
+)
+
+// requesterIdentity is the originating identity of {"user_id":"requester-guid"}.
+const requesterIdentity = "cloudfoundry eyJ1c2VyX2lkIjoicmVxdWVzdGVyLWd1aWQifQ=="
+
+// failureStatus returns the HTTP status of an OSB error, or 0 for other errors.
+func failureStatus(err error) int {
+	var failure *brokerapi.FailureResponse
+	if !errors.As(err, &failure) {
+		return 0
+	}
+	return failure.ValidatedStatusCode(nil)
+}
+
+// cfRoles returns CF roles of types.
+func cfRoles(types ...string) []*cf.Role {
+	roles := []*cf.Role{}
+	for _, t := range types {
+		roles = append(roles, &cf.Role{Type: t})
+	}
+	return roles
+}
+
 type FakeUAAClient struct {
 	mock.Mock
 	userGUID   string
@@ -22,31 +61,88 @@ type FakeUAAClient struct {
 	clientGUID string
 }
 
-func (c *FakeUAAClient) CreateClient(client Client) (Client, error) {
-	c.Called(client)
-	return Client{ID: c.clientGUID}, nil
+func (c *FakeUAAClient) GetClient(ctx context.Context, clientID string) (Client, error) {
+	args := c.Called(clientID)
+	return args.Get(0).(Client), args.Error(1)
+}
+
+func (c *FakeUAAClient) ListClients(ctx context.Context, filter string) ([]Client, error) {
+	args := c.Called(filter)
+	return args.Get(0).([]Client), args.Error(1)
+}
+
+func (c *FakeUAAClient) CreateClient(ctx context.Context, client Client) (Client, error) {
+	args := c.Called(client)
+	return Client{ID: c.clientGUID}, args.Error(1)
+}
+
+func (c *FakeUAAClient) ChangeClientSecret(ctx context.Context, clientID, secret string) error {
+	args := c.Called(clientID, secret)
+	return args.Error(0)
+}
+
+func (c *FakeUAAClient) AddClientSecret(ctx context.Context, clientID, secret string) error {
+	args := c.Called(clientID, secret)
+	return args.Error(0)
 }
 
-func (c *FakeUAAClient) DeleteClient(clientID string) error {
+func (c *FakeUAAClient) DeleteClientSecret(ctx context.Context, clientID string) error {
 	args := c.Called(clientID)
 	return args.Error(0)
 }
 
-func (c *FakeUAAClient) GetUser(userID string) (User, error) {
-	c.Called(userID)
-	return User{ID: c.userGUID}, nil
+func (c *FakeUAAClient) DeleteClient(ctx context.Context, clientID string) error {
+	args := c.Called(clientID)
+	return args.Error(0)
+}
+
+func (c *FakeUAAClient) GetUser(ctx context.Context, userID string) (User, error) {
+	args := c.Called(userID)
+	return User{ID: c.userGUID, UserName: c.userName}, args.Error(1)
+}
+
+func (c *FakeUAAClient) CreateUser(ctx context.Context, user User) (User, error) {
+	args := c.Called(user)
+	return User{ID: c.userGUID, UserName: c.userName}, args.Error(1)
 }
 
-func (c *FakeUAAClient) CreateUser(user User) (User, error) {
-	c.Called(user)
-	return User{ID: c.userGUID, UserName: c.userName}, nil
+func (c *FakeUAAClient) ChangeUserPassword(ctx context.Context, userID, password string) error {
+	args := c.Called(userID, password)
+	return args.Error(0)
 }
 
-func (c *FakeUAAClient) DeleteUser(userID string) error {
+func (c *FakeUAAClient) DeleteUser(ctx context.Context, userID string) error {
 	c.Called(userID)
 	return nil
 }
 
+// newTestBroker returns a broker with the catalog in config.json, the given
+// clients and a fixed password. Tests change its config as they need.
+func newTestBroker(uaaClient AuthClient, cfClient PAASClient) DeployerAccountBroker {
+	plans, err := LoadPlanRegistry("config.json", nil)
+	Expect(err).NotTo(HaveOccurred())
+	oidc, err := NewOIDCEndpoints("https://uaa.example.gov", "uaa", "")
+	Expect(err).NotTo(HaveOccurred())
+
+	return DeployerAccountBroker{
+		uaaClient: uaaClient,
+		cfClient:  cfClient,
+		logger:    lagertest.NewTestLogger("broker-test"),
+		generatePassword: func(int) string {
+			return "password"
+		},
+		config: Config{
+			EmailAddress:         "fake@fake.org",
+			PasswordLength:       32,
+			AccessTokenValidity:  600,
+			RefreshTokenValidity: 86400,
+		},
+		operations: NewOperationStore(),
+		plans:      plans,
+		oidc:       oidc,
+	}
+}
+
 var _ = Describe("broker", func() {
 	var (
 		uaaClient FakeUAAClient
@@ -57,46 +153,44 @@ var _ = Describe("broker", func() {
 	BeforeEach(func() {
 		uaaClient = FakeUAAClient{userGUID: "user-guid", userName: "binding-guid"}
 		cfClient = mocks.PAASClient{}
-		broker = DeployerAccountBroker{
-			uaaClient: &uaaClient,
-			cfClient:  &cfClient,
-			logger:    lagertest.NewTestLogger("broker-test"),
-			generatePassword: func(int) string {
-				return "password"
-			},
-			config: Config{
-				EmailAddress:         "fake@fake.org",
-				PasswordLength:       32,
-				AccessTokenValidity:  600,
-				RefreshTokenValidity: 86400,
-			},
-		}
+		broker = newTestBroker(&uaaClient, &cfClient)
 	})
 
 	Describe("uaa client", func() {
+		BeforeEach(func() {
+			cfClient.On("ServiceInstanceByGuid", mock.Anything, "instance-guid").Return(&cf.ServiceInstance{}, nil).Maybe()
+			uaaClient.On("GetClient", "instance-guid").Return(Client{}, &UAAError{StatusCode: 404}).Maybe()
+		})
+
 		Describe("parse options", func() {
-			It("returns error options when no parameters are specified", func() {
-				options, err := parseBindOptions(brokerapi.BindDetails{
+			var oauthPlan RegisteredPlan
+
+			BeforeEach(func() {
+				oauthPlan, _ = broker.plans.Plan(clientAccountGUID, oauthClientGUID)
+			})
+
+			It("returns empty options when no parameters are specified", func() {
+				options, err := parseBindOptions(oauthPlan, brokerapi.BindDetails{
 					RawParameters: []byte(``),
-				})
-				Expect(err).To(HaveOccurred())
+				}, BindOptions{})
+				Expect(err).NotTo(HaveOccurred())
 				Expect(options).To(Equal(BindOptions{}))
 			})
 
-			It("returns error options when no redirect URI specified", func() {
-				options, err := parseBindOptions(brokerapi.BindDetails{
+			It("leaves the redirect URI to the grant type rules", func() {
+				options, err := parseBindOptions(oauthPlan, brokerapi.BindDetails{
 					RawParameters: []byte(`{"redirect_uri":[]}`),
-				})
-				Expect(err).To(HaveOccurred())
+				}, BindOptions{})
+				Expect(err).NotTo(HaveOccurred())
 				Expect(options).To(Equal(BindOptions{
 					RedirectURI: []string{},
 				}))
 			})
 
 			It("returns options with redirect URI", func() {
-				options, err := parseBindOptions(brokerapi.BindDetails{
+				options, err := parseBindOptions(oauthPlan, brokerapi.BindDetails{
 					RawParameters: []byte(`{"redirect_uri":["example.com"]}`),
-				})
+				}, BindOptions{})
 				Expect(err).NotTo(HaveOccurred())
 				Expect(options).To(Equal(BindOptions{
 					RedirectURI: []string{"example.com"},
@@ -104,8 +198,22 @@ var _ = Describe("broker", func() {
 			})
 
 			It("returns options with scopes", func() {
-				options, err := parseBindOptions(brokerapi.BindDetails{
+				options, err := parseBindOptions(oauthPlan, brokerapi.BindDetails{
 					RawParameters: []byte(`{"redirect_uri":["example.com"], "scopes":["scope1"]}`),
+				}, BindOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(options).To(Equal(BindOptions{
+					RedirectURI: []string{"example.com"},
+					Scopes:      []string{"scope1"},
+				}))
+			})
+
+			It("fills in options from defaults", func() {
+				options, err := parseBindOptions(oauthPlan, brokerapi.BindDetails{
+					RawParameters: []byte(`{"scopes":["scope1"]}`),
+				}, BindOptions{
+					RedirectURI: []string{"example.com"},
+					Scopes:      []string{"openid"},
 				})
 				Expect(err).NotTo(HaveOccurred())
 				Expect(options).To(Equal(BindOptions{
@@ -115,9 +223,9 @@ var _ = Describe("broker", func() {
 			})
 
 			It("returns options with allowpublic", func() {
-				options, err := parseBindOptions(brokerapi.BindDetails{
+				options, err := parseBindOptions(oauthPlan, brokerapi.BindDetails{
 					RawParameters: []byte(`{"redirect_uri":["example.com"], "allowpublic": true}`),
-				})
+				}, BindOptions{})
 				Expect(err).NotTo(HaveOccurred())
 				allowPublicTrue := true
 				Expect(options).To(Equal(BindOptions{
@@ -127,6 +235,28 @@ var _ = Describe("broker", func() {
 			})
 		})
 
+		Describe("bind details", func() {
+			It("decodes the platform context and bind resource", func() {
+				details := BindDetails{}
+				err := json.Unmarshal([]byte(`{
+					"service_id": "service-guid",
+					"plan_id": "plan-guid",
+					"bind_resource": {"app_guid": "app-guid", "space_guid": "space-guid"},
+					"context": {"platform": "cloudfoundry", "organization_guid": "org-guid", "space_guid": "space-guid"},
+					"parameters": {"redirect_uri": ["https://cloud.gov"]}
+				}`), &details)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(details.ServiceID).To(Equal("service-guid"))
+				Expect(details.BindResource).To(Equal(&BindResource{AppGuid: "app-guid", SpaceGuid: "space-guid"}))
+				Expect(string(details.RawParameters)).To(Equal(`{"redirect_uri": ["https://cloud.gov"]}`))
+
+				platformContext, err := details.PlatformContext()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(platformContext.OrganizationGUID).To(Equal("org-guid"))
+				Expect(platformContext.SpaceGUID).To(Equal("space-guid"))
+			})
+		})
+
 		Describe("provision", func() {
 			It("returns a binding", func() {
 				uaaClient.On("CreateClient", Client{
@@ -146,6 +276,7 @@ var _ = Describe("broker", func() {
 					brokerapi.BindDetails{
 						AppGUID:       "app-guid",
 						ServiceID:     clientAccountGUID,
+						PlanID:        oauthClientGUID,
 						RawParameters: []byte(`{"redirect_uri": ["https://cloud.gov"]}`),
 					},
 				)
@@ -170,12 +301,12 @@ var _ = Describe("broker", func() {
 					"instance-guid",
 					"binding-guid",
 					brokerapi.BindDetails{
-						AppGUID:   "app-guid",
 						ServiceID: clientAccountGUID,
+						PlanID:    oauthClientGUID,
 					},
 				)
 				Expect(err).To(HaveOccurred())
-				Expect(err.Error()).To(Equal(`must pass JSON configuration with field "redirect_uri"`))
+				Expect(err.Error()).To(Equal(`must pass field "redirect_uri"`))
 			})
 
 			It("errors if params incomplete", func() {
@@ -194,8 +325,8 @@ var _ = Describe("broker", func() {
 					"instance-guid",
 					"binding-guid",
 					brokerapi.BindDetails{
-						AppGUID:       "app-guid",
 						ServiceID:     clientAccountGUID,
+						PlanID:        oauthClientGUID,
 						RawParameters: []byte(`{}`),
 					},
 				)
@@ -221,6 +352,7 @@ var _ = Describe("broker", func() {
 					brokerapi.BindDetails{
 						AppGUID:       "app-guid",
 						ServiceID:     clientAccountGUID,
+						PlanID:        oauthClientGUID,
 						RawParameters: []byte(`{"redirect_uri": ["https://cloud.gov"], "scopes": ["openid"]}`),
 					},
 				)
@@ -237,6 +369,7 @@ var _ = Describe("broker", func() {
 					brokerapi.BindDetails{
 						AppGUID:       "app-guid",
 						ServiceID:     clientAccountGUID,
+						PlanID:        oauthClientGUID,
 						RawParameters: []byte(`{"redirect_uri": ["https://cloud.gov"], "scopes": ["cloud_controller.write"]}`),
 					},
 				)
@@ -263,6 +396,7 @@ var _ = Describe("broker", func() {
 					brokerapi.BindDetails{
 						AppGUID:       "app-guid",
 						ServiceID:     clientAccountGUID,
+						PlanID:        oauthClientGUID,
 						RawParameters: []byte(`{"redirect_uri": ["https://cloud.gov"], "scopes": ["openid"], "allowpublic": true}`),
 					},
 				)
@@ -272,6 +406,236 @@ var _ = Describe("broker", func() {
 			})
 		})
 
+		Describe("grant types", func() {
+			bindWith := func(params string) (brokerapi.Binding, error) {
+				return broker.Bind(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					brokerapi.BindDetails{
+						AppGUID:       "app-guid",
+						ServiceID:     clientAccountGUID,
+						PlanID:        oauthClientGUID,
+						RawParameters: []byte(params),
+					},
+				)
+			}
+
+			It("creates client credentials clients with the requested authorities", func() {
+				uaaClient.On("CreateClient", Client{
+					ID:                   "binding-guid",
+					AuthorizedGrantTypes: []string{"client_credentials"},
+					Scope:                []string{"openid"},
+					Authorities:          []string{"uaa.resource"},
+					ClientSecret:         "password",
+					AccessTokenValidity:  600,
+					RefreshTokenValidity: 86400,
+				}).Return(Client{}, nil)
+
+				binding, err := bindWith(`{"grant_types": ["client_credentials"], "authorities": ["uaa.resource"]}`)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(binding.Credentials).To(HaveKeyWithValue("client_secret", "password"))
+				uaaClient.AssertExpectations(GinkgoT())
+			})
+
+			It("includes the UAA endpoints in the credentials", func() {
+				uaaClient.On("CreateClient", mock.Anything).Return(Client{}, nil)
+
+				binding, err := bindWith(`{"redirect_uri": ["https://cloud.gov"]}`)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(binding.Credentials).To(Equal(map[string]string{
+					"client_id":     "binding-guid",
+					"client_secret": "password",
+					"auth_url":      "https://uaa.example.gov/oauth/authorize",
+					"token_url":     "https://uaa.example.gov/oauth/token",
+					"userinfo_url":  "https://uaa.example.gov/userinfo",
+					"jwks_url":      "https://uaa.example.gov/token_keys",
+					"issuer":        "https://uaa.example.gov/oauth/token",
+					"logout_url":    "https://uaa.example.gov/logout.do",
+				}))
+			})
+
+			It("returns no secret for public clients", func() {
+				uaaClient.On("CreateClient", mock.MatchedBy(func(client Client) bool {
+					return client.AllowPublic && sameStrings(client.AuthorizedGrantTypes, []string{"authorization_code"})
+				})).Return(Client{}, nil)
+
+				binding, err := bindWith(`{"grant_types": ["authorization_code"], "redirect_uri": ["https://cloud.gov"], "allowpublic": true}`)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(binding.Credentials).To(HaveKeyWithValue("client_id", "binding-guid"))
+				Expect(binding.Credentials).NotTo(HaveKey("client_secret"))
+			})
+
+			It("enforces the rules of each grant type", func() {
+				cases := map[string]string{
+					`Field "redirect_uri" requires grant type authorization_code`:     `{"grant_types": ["client_credentials"], "redirect_uri": ["https://cloud.gov"]}`,
+					`Field "authorities" requires grant type client_credentials`:      `{"redirect_uri": ["https://cloud.gov"], "authorities": ["uaa.resource"]}`,
+					`Field "allowpublic" requires grant type authorization_code`:      `{"grant_types": ["client_credentials"], "allowpublic": true}`,
+					"Public clients may not use grant type client_credentials":        `{"grant_types": ["authorization_code", "client_credentials"], "redirect_uri": ["https://cloud.gov"], "allowpublic": true}`,
+					"Grant type refresh_token requires grant type authorization_code": `{"grant_types": ["client_credentials", "refresh_token"]}`,
+				}
+				for expected, params := range cases {
+					_, err := bindWith(params)
+					Expect(err).To(MatchError(expected), params)
+					Expect(failureStatus(err)).To(Equal(http.StatusBadRequest), params)
+				}
+				uaaClient.AssertNotCalled(GinkgoT(), "CreateClient", mock.Anything)
+			})
+		})
+
+		Describe("org scopes", func() {
+			BeforeEach(func() {
+				buf, err := os.ReadFile("config.json")
+				Expect(err).NotTo(HaveOccurred())
+				var services []Service
+				Expect(json.Unmarshal(buf, &services)).To(Succeed())
+				services[0].Plans[0].Settings.OrgScopes = map[string][]string{
+					"trusted-org": {"profile", "email"},
+				}
+				broker.plans, err = NewPlanRegistry(services)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			bindInOrg := func(orgContext string) error {
+				_, err := broker.BindAsync(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					BindDetails{
+						BindDetails: brokerapi.BindDetails{
+							ServiceID:     clientAccountGUID,
+							PlanID:        oauthClientGUID,
+							RawParameters: []byte(`{"redirect_uri": ["https://cloud.gov"], "scopes": ["openid", "profile"]}`),
+						},
+						RawContext: []byte(orgContext),
+					},
+					false,
+				)
+				return err
+			}
+
+			It("grants the scopes allowed for the org", func() {
+				uaaClient.On("CreateClient", mock.MatchedBy(func(client Client) bool {
+					return sameStrings(client.Scope, []string{"openid", "profile"})
+				})).Return(Client{}, nil)
+
+				Expect(bindInOrg(`{"organization_guid":"org-guid","space_guid":"space-guid","organization_name":"trusted-org"}`)).To(Succeed())
+				uaaClient.AssertExpectations(GinkgoT())
+			})
+
+			It("looks up the org name when the platform does not send it", func() {
+				org := &cf.Organization{Name: "trusted-org"}
+				cfClient.On("GetOrganizationByGuid", mock.Anything, "org-guid").Return(org, nil)
+				uaaClient.On("CreateClient", mock.Anything).Return(Client{}, nil)
+
+				Expect(bindInOrg(`{"organization_guid":"org-guid","space_guid":"space-guid"}`)).To(Succeed())
+				cfClient.AssertExpectations(GinkgoT())
+			})
+
+			It("rejects the scopes in other orgs", func() {
+				err := bindInOrg(`{"organization_guid":"org-guid","space_guid":"space-guid","organization_name":"other-org"}`)
+				Expect(err).To(MatchError("Scope(s) not permitted: profile"))
+				Expect(failureStatus(err)).To(Equal(http.StatusBadRequest))
+				uaaClient.AssertNotCalled(GinkgoT(), "CreateClient", mock.Anything)
+			})
+		})
+
+		Describe("repeated bind", func() {
+			requested := Client{
+				ID:                   "binding-guid",
+				AuthorizedGrantTypes: []string{"authorization_code", "refresh_token"},
+				Scope:                []string{"openid"},
+				RedirectURI:          []string{"https://cloud.gov"},
+				ClientSecret:         "password",
+				AccessTokenValidity:  600,
+				RefreshTokenValidity: 86400,
+			}
+
+			It("regenerates the secret of an identical client", func() {
+				uaaClient.On("CreateClient", requested).Return(Client{}, &UAAError{StatusCode: 409})
+				uaaClient.On("GetClient", "binding-guid").Return(Client{
+					ID:                   "binding-guid",
+					AuthorizedGrantTypes: []string{"refresh_token", "authorization_code"},
+					Scope:                []string{"openid"},
+					RedirectURI:          []string{"https://cloud.gov"},
+					AccessTokenValidity:  600,
+					RefreshTokenValidity: 86400,
+				}, nil)
+				uaaClient.On("ChangeClientSecret", "binding-guid", "password").Return(nil)
+
+				binding, err := broker.BindAsync(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					BindDetails{BindDetails: brokerapi.BindDetails{
+						ServiceID:     clientAccountGUID,
+						PlanID:        oauthClientGUID,
+						RawParameters: []byte(`{"redirect_uri": ["https://cloud.gov"]}`),
+					}},
+					false,
+				)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(binding.AlreadyExists).To(BeTrue())
+				Expect(binding.Credentials).To(HaveKeyWithValue("client_secret", "password"))
+				uaaClient.AssertExpectations(GinkgoT())
+			})
+
+			It("regenerates the secret of a client that UAA reports with placeholder authorities", func() {
+				uaaClient.On("CreateClient", requested).Return(Client{}, &UAAError{StatusCode: 409})
+				uaaClient.On("GetClient", "binding-guid").Return(Client{
+					ID:                   "binding-guid",
+					AuthorizedGrantTypes: []string{"authorization_code", "refresh_token"},
+					Scope:                []string{"openid"},
+					Authorities:          []string{"uaa.none"},
+					RedirectURI:          []string{"https://cloud.gov"},
+					AccessTokenValidity:  600,
+					RefreshTokenValidity: 86400,
+				}, nil)
+				uaaClient.On("ChangeClientSecret", "binding-guid", "password").Return(nil)
+
+				binding, err := broker.BindAsync(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					BindDetails{BindDetails: brokerapi.BindDetails{
+						ServiceID:     clientAccountGUID,
+						PlanID:        oauthClientGUID,
+						RawParameters: []byte(`{"redirect_uri": ["https://cloud.gov"]}`),
+					}},
+					false,
+				)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(binding.AlreadyExists).To(BeTrue())
+				uaaClient.AssertExpectations(GinkgoT())
+			})
+
+			It("rejects a conflicting client", func() {
+				uaaClient.On("CreateClient", requested).Return(Client{}, &UAAError{StatusCode: 409})
+				uaaClient.On("GetClient", "binding-guid").Return(Client{
+					ID:                   "binding-guid",
+					AuthorizedGrantTypes: []string{"authorization_code", "refresh_token"},
+					Scope:                []string{"openid"},
+					RedirectURI:          []string{"https://example.com"},
+					AccessTokenValidity:  600,
+					RefreshTokenValidity: 86400,
+				}, nil)
+
+				_, err := broker.BindAsync(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					BindDetails{BindDetails: brokerapi.BindDetails{
+						ServiceID:     clientAccountGUID,
+						PlanID:        oauthClientGUID,
+						RawParameters: []byte(`{"redirect_uri": ["https://cloud.gov"]}`),
+					}},
+					false,
+				)
+				Expect(err).To(Equal(brokerapi.ErrBindingAlreadyExists))
+				uaaClient.AssertNotCalled(GinkgoT(), "ChangeClientSecret", mock.Anything, mock.Anything)
+			})
+		})
+
 		Describe("unbind", func() {
 			It("does not return an error", func() {
 				uaaClient.On("DeleteClient", "binding-guid").Return(nil)
@@ -282,6 +646,7 @@ var _ = Describe("broker", func() {
 					"binding-guid",
 					brokerapi.UnbindDetails{
 						ServiceID: clientAccountGUID,
+						PlanID:    oauthClientGUID,
 					},
 				)
 				Expect(err).NotTo(HaveOccurred())
@@ -289,7 +654,7 @@ var _ = Describe("broker", func() {
 			})
 
 			It("does not return an error for a 404 response on deletion", func() {
-				uaaClient.On("DeleteClient", "binding-guid2").Return(fmt.Errorf("Expected status 200; got: %d", 404))
+				uaaClient.On("DeleteClient", "binding-guid2").Return(&UAAError{StatusCode: 404})
 
 				err := broker.Unbind(
 					context.Background(),
@@ -297,6 +662,7 @@ var _ = Describe("broker", func() {
 					"binding-guid2",
 					brokerapi.UnbindDetails{
 						ServiceID: clientAccountGUID,
+						PlanID:    oauthClientGUID,
 					},
 				)
 				Expect(err).NotTo(HaveOccurred())
@@ -304,7 +670,7 @@ var _ = Describe("broker", func() {
 			})
 
 			It("does return an error for a response other than 200/404 on deletion", func() {
-				uaaClient.On("DeleteClient", "binding-guid3").Return(fmt.Errorf("Expected status 200; got: %d", 500))
+				uaaClient.On("DeleteClient", "binding-guid3").Return(&UAAError{StatusCode: 500})
 
 				err := broker.Unbind(
 					context.Background(),
@@ -312,6 +678,7 @@ var _ = Describe("broker", func() {
 					"binding-guid3",
 					brokerapi.UnbindDetails{
 						ServiceID: clientAccountGUID,
+						PlanID:    oauthClientGUID,
 					},
 				)
 				Expect(err).To(HaveOccurred())
@@ -328,6 +695,7 @@ var _ = Describe("broker", func() {
 					"instance-guid",
 					brokerapi.DeprovisionDetails{
 						ServiceID: clientAccountGUID,
+						PlanID:    oauthClientGUID,
 					},
 					false,
 				)
@@ -337,13 +705,14 @@ var _ = Describe("broker", func() {
 		})
 
 		It("does not return an error for a 404 response on deletion", func() {
-			uaaClient.On("DeleteClient", "instance-guid2").Return(fmt.Errorf("Expected status 200; got: %d", 404))
+			uaaClient.On("DeleteClient", "instance-guid2").Return(&UAAError{StatusCode: 404})
 
 			_, err := broker.Deprovision(
 				context.Background(),
 				"instance-guid2",
 				brokerapi.DeprovisionDetails{
 					ServiceID: clientAccountGUID,
+					PlanID:    oauthClientGUID,
 				},
 				false,
 			)
@@ -352,13 +721,14 @@ var _ = Describe("broker", func() {
 		})
 
 		It("does return an error for a response other than 200/404 on deletion", func() {
-			uaaClient.On("DeleteClient", "instance-guid3").Return(fmt.Errorf("Expected status 200; got: %d", 500))
+			uaaClient.On("DeleteClient", "instance-guid3").Return(&UAAError{StatusCode: 500})
 
 			_, err := broker.Deprovision(
 				context.Background(),
 				"instance-guid3",
 				brokerapi.DeprovisionDetails{
 					ServiceID: clientAccountGUID,
+					PlanID:    oauthClientGUID,
 				},
 				false,
 			)
@@ -390,8 +760,8 @@ var _ = Describe("broker", func() {
 			user := &cf.User{}
 			user.GUID = "user-guid"
 			It("returns a provision service spec for space-deployer", func() {
-				cfClient.On("ServiceInstanceByGuid", "instance-guid").Return(svcInst, nil)
-				cfClient.On("GetSpaceByGuid", "space-guid").Return(space, nil)
+				cfClient.On("ServiceInstanceByGuid", mock.Anything, "instance-guid").Return(svcInst, nil)
+				cfClient.On("GetSpaceByGuid", mock.Anything, "space-guid").Return(space, nil)
 				uaaClient.On("CreateUser", User{
 					UserName: "binding-guid",
 					Password: "password",
@@ -400,9 +770,9 @@ var _ = Describe("broker", func() {
 						Primary: true,
 					}},
 				}).Return(User{ID: "user-guid"}, nil)
-				cfClient.On("CreateUser", "user-guid").Return(user, nil)
-				cfClient.On("AssociateOrgUserByUsername", "org-guid", "binding-guid").Return(&cf.Role{}, nil)
-				cfClient.On("AssociateSpaceDeveloperByUsername", "space-guid", "binding-guid").Return(&cf.Role{}, nil)
+				cfClient.On("CreateUser", mock.Anything, "user-guid").Return(user, nil)
+				cfClient.On("AssociateOrgUserByUsername", mock.Anything, "org-guid", "binding-guid").Return(&cf.Role{}, nil)
+				cfClient.On("AssociateSpaceDeveloperByUsername", mock.Anything, "space-guid", "binding-guid").Return(&cf.Role{}, nil)
 
 				_, err := broker.Bind(
 					context.Background(),
@@ -420,8 +790,8 @@ var _ = Describe("broker", func() {
 			})
 
 			It("returns a provision service spec for space-auditor", func() {
-				cfClient.On("ServiceInstanceByGuid", "instance-guid").Return(svcInst, nil)
-				cfClient.On("GetSpaceByGuid", "space-guid").Return(space, nil)
+				cfClient.On("ServiceInstanceByGuid", mock.Anything, "instance-guid").Return(svcInst, nil)
+				cfClient.On("GetSpaceByGuid", mock.Anything, "space-guid").Return(space, nil)
 				uaaClient.On("CreateUser", User{
 					UserName: "binding-guid",
 					Password: "password",
@@ -430,9 +800,9 @@ var _ = Describe("broker", func() {
 						Primary: true,
 					}},
 				}).Return(User{ID: "user-guid"}, nil)
-				cfClient.On("CreateUser", "user-guid").Return(user, nil)
-				cfClient.On("AssociateOrgUserByUsername", "org-guid", "binding-guid").Return(&cf.Role{}, nil)
-				cfClient.On("AssociateSpaceAuditorByUsername", "space-guid", "binding-guid").Return(&cf.Role{}, nil)
+				cfClient.On("CreateUser", mock.Anything, "user-guid").Return(user, nil)
+				cfClient.On("AssociateOrgUserByUsername", mock.Anything, "org-guid", "binding-guid").Return(&cf.Role{}, nil)
+				cfClient.On("AssociateSpaceAuditorByUsername", mock.Anything, "space-guid", "binding-guid").Return(&cf.Role{}, nil)
 
 				_, err := broker.Bind(
 					context.Background(),
@@ -448,26 +818,1698 @@ var _ = Describe("broker", func() {
 				uaaClient.AssertExpectations(GinkgoT())
 				cfClient.AssertExpectations(GinkgoT())
 			})
-		})
 
-		Describe("deprovision", func() {
-			It("returns a deprovision service spec", func() {
-				uaaClient.On("GetUser", "binding-guid").Return(User{ID: "user-guid"}, nil)
-				uaaClient.On("DeleteUser", "user-guid").Return(nil)
-				cfClient.On("DeleteUser", "user-guid").Return(nil)
+			It("returns a provision service spec for space-manager", func() {
+				cfClient.On("ServiceInstanceByGuid", mock.Anything, "instance-guid").Return(svcInst, nil)
+				cfClient.On("GetSpaceByGuid", mock.Anything, "space-guid").Return(space, nil)
+				uaaClient.On("CreateUser", User{
+					UserName: "binding-guid",
+					Password: "password",
+					Emails: []Email{{
+						Value:   "fake@fake.org",
+						Primary: true,
+					}},
+				}).Return(User{ID: "user-guid"}, nil)
+				cfClient.On("CreateUser", mock.Anything, "user-guid").Return(user, nil)
+				cfClient.On("AssociateOrgUserByUsername", mock.Anything, "org-guid", "binding-guid").Return(&cf.Role{}, nil)
+				cfClient.On("AssociateSpaceManagerByUsername", mock.Anything, "space-guid", "binding-guid").Return(&cf.Role{}, nil)
+				cfClient.On("ListSpaceRolesByUser", mock.Anything, "space-guid", "requester-guid").Return(cfRoles("space_manager"), nil)
 
-				err := broker.Unbind(
+				_, err := broker.BindAsync(
 					context.Background(),
 					"instance-guid",
 					"binding-guid",
-					brokerapi.UnbindDetails{
+					BindDetails{
+						BindDetails: brokerapi.BindDetails{
+							AppGUID:   "app-guid",
+							ServiceID: userAccountGUID,
+							PlanID:    spaceManagerGUID,
+						},
+						OriginatingIdentity: requesterIdentity,
+					},
+					false,
+				)
+				Expect(err).NotTo(HaveOccurred())
+				uaaClient.AssertExpectations(GinkgoT())
+				cfClient.AssertExpectations(GinkgoT())
+			})
+
+			It("refuses space-manager to a requesting user who is not a space manager", func() {
+				cfClient.On("ServiceInstanceByGuid", mock.Anything, "instance-guid").Return(svcInst, nil)
+				cfClient.On("GetSpaceByGuid", mock.Anything, "space-guid").Return(space, nil)
+				cfClient.On("ListSpaceRolesByUser", mock.Anything, "space-guid", "requester-guid").Return(cfRoles("space_developer"), nil)
+
+				_, err := broker.BindAsync(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					BindDetails{
+						BindDetails: brokerapi.BindDetails{
+							AppGUID:   "app-guid",
+							ServiceID: userAccountGUID,
+							PlanID:    spaceManagerGUID,
+						},
+						OriginatingIdentity: requesterIdentity,
+					},
+					false,
+				)
+				Expect(err).To(MatchError("Requesting user does not hold roles space_manager in space space-guid"))
+				Expect(failureStatus(err)).To(Equal(http.StatusForbidden))
+				uaaClient.AssertNotCalled(GinkgoT(), "CreateUser", mock.Anything)
+			})
+
+			It("refuses space-manager when the platform does not identify the requesting user", func() {
+				cfClient.On("ServiceInstanceByGuid", mock.Anything, "instance-guid").Return(svcInst, nil)
+				cfClient.On("GetSpaceByGuid", mock.Anything, "space-guid").Return(space, nil)
+
+				_, err := broker.Bind(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					brokerapi.BindDetails{
+						AppGUID:   "app-guid",
+						ServiceID: userAccountGUID,
+						PlanID:    spaceManagerGUID,
+					},
+				)
+				Expect(err).To(MatchError(ContainSubstring("requires the platform to identify the requesting user")))
+				uaaClient.AssertNotCalled(GinkgoT(), "CreateUser", mock.Anything)
+			})
+
+			It("returns a provision service spec for space-supporter", func() {
+				cfClient.On("ServiceInstanceByGuid", mock.Anything, "instance-guid").Return(svcInst, nil)
+				cfClient.On("GetSpaceByGuid", mock.Anything, "space-guid").Return(space, nil)
+				uaaClient.On("CreateUser", User{
+					UserName: "binding-guid",
+					Password: "password",
+					Emails: []Email{{
+						Value:   "fake@fake.org",
+						Primary: true,
+					}},
+				}).Return(User{ID: "user-guid"}, nil)
+				cfClient.On("CreateUser", mock.Anything, "user-guid").Return(user, nil)
+				cfClient.On("AssociateOrgUserByUsername", mock.Anything, "org-guid", "binding-guid").Return(&cf.Role{}, nil)
+				cfClient.On("AssociateSpaceSupporterByUsername", mock.Anything, "space-guid", "binding-guid").Return(&cf.Role{}, nil)
+
+				_, err := broker.Bind(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					brokerapi.BindDetails{
+						AppGUID:   "app-guid",
 						ServiceID: userAccountGUID,
+						PlanID:    spaceSupporterGUID,
 					},
 				)
 				Expect(err).NotTo(HaveOccurred())
 				uaaClient.AssertExpectations(GinkgoT())
 				cfClient.AssertExpectations(GinkgoT())
 			})
+
+			It("rejects plans that are not in the catalog", func() {
+				_, err := broker.BindAsync(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					BindDetails{
+						BindDetails: brokerapi.BindDetails{
+							ServiceID: userAccountGUID,
+							PlanID:    oauthClientGUID,
+						},
+					},
+					true,
+				)
+				Expect(err).To(MatchError(ContainSubstring("not found in catalog")))
+				uaaClient.AssertNotCalled(GinkgoT(), "CreateUser", mock.Anything)
+			})
+
+			It("uses the space and org from the platform context", func() {
+				uaaClient.On("CreateUser", mock.Anything).Return(User{ID: "user-guid"}, nil)
+				cfClient.On("CreateUser", mock.Anything, "user-guid").Return(user, nil)
+				cfClient.On("AssociateOrgUserByUsername", mock.Anything, "context-org-guid", "binding-guid").Return(&cf.Role{}, nil)
+				cfClient.On("AssociateSpaceDeveloperByUsername", mock.Anything, "context-space-guid", "binding-guid").Return(&cf.Role{}, nil)
+
+				_, err := broker.BindAsync(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					BindDetails{
+						BindDetails: brokerapi.BindDetails{
+							ServiceID: userAccountGUID,
+							PlanID:    deployerGUID,
+						},
+						RawContext: []byte(`{"platform":"cloudfoundry","organization_guid":"context-org-guid","space_guid":"context-space-guid"}`),
+					},
+					false,
+				)
+				Expect(err).NotTo(HaveOccurred())
+				cfClient.AssertExpectations(GinkgoT())
+				cfClient.AssertNotCalled(GinkgoT(), "ServiceInstanceByGuid", mock.Anything, mock.Anything)
+				cfClient.AssertNotCalled(GinkgoT(), "GetSpaceByGuid", mock.Anything, mock.Anything)
+			})
+
+			It("ignores the space of the app in bind_resource", func() {
+				cfClient.On("ServiceInstanceByGuid", mock.Anything, "instance-guid").Return(svcInst, nil)
+				cfClient.On("GetSpaceByGuid", mock.Anything, "space-guid").Return(space, nil)
+				uaaClient.On("CreateUser", mock.Anything).Return(User{ID: "user-guid"}, nil)
+				cfClient.On("CreateUser", mock.Anything, "user-guid").Return(user, nil)
+				cfClient.On("AssociateOrgUserByUsername", mock.Anything, "org-guid", "binding-guid").Return(&cf.Role{}, nil)
+				cfClient.On("AssociateSpaceDeveloperByUsername", mock.Anything, "space-guid", "binding-guid").Return(&cf.Role{}, nil)
+
+				_, err := broker.BindAsync(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					BindDetails{
+						BindDetails: brokerapi.BindDetails{
+							ServiceID: userAccountGUID,
+							PlanID:    deployerGUID,
+						},
+						BindResource: &BindResource{AppGuid: "app-guid", SpaceGuid: "consumer-space-guid"},
+					},
+					false,
+				)
+				Expect(err).NotTo(HaveOccurred())
+				cfClient.AssertExpectations(GinkgoT())
+				cfClient.AssertNotCalled(GinkgoT(), "GetSpaceByGuid", mock.Anything, "consumer-space-guid")
+			})
+
+			It("rejects a malformed platform context", func() {
+				_, err := broker.BindAsync(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					BindDetails{
+						BindDetails: brokerapi.BindDetails{
+							ServiceID: userAccountGUID,
+							PlanID:    deployerGUID,
+						},
+						RawContext: []byte(`"not an object"`),
+					},
+					false,
+				)
+				Expect(err).To(HaveOccurred())
+				cfClient.AssertNotCalled(GinkgoT(), "ServiceInstanceByGuid", mock.Anything, mock.Anything)
+			})
+
+			It("rolls back the uaa and cf users when the org role cannot be granted", func() {
+				cfClient.On("ServiceInstanceByGuid", mock.Anything, "instance-guid").Return(svcInst, nil)
+				cfClient.On("GetSpaceByGuid", mock.Anything, "space-guid").Return(space, nil)
+				uaaClient.On("CreateUser", mock.Anything).Return(User{ID: "user-guid"}, nil)
+				cfClient.On("CreateUser", mock.Anything, "user-guid").Return(user, nil)
+				cfClient.On("AssociateOrgUserByUsername", mock.Anything, "org-guid", "binding-guid").Return((*cf.Role)(nil), errors.New("org role failed"))
+				cfClient.On("DeleteUser", mock.Anything, "user-guid").Return(nil)
+				uaaClient.On("DeleteUser", "user-guid").Return(nil)
+
+				_, err := broker.Bind(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					brokerapi.BindDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    deployerGUID,
+					},
+				)
+				Expect(err).To(MatchError("org role failed"))
+				uaaClient.AssertExpectations(GinkgoT())
+				cfClient.AssertExpectations(GinkgoT())
+				cfClient.AssertNotCalled(GinkgoT(), "DeleteRole", mock.Anything, mock.Anything)
+			})
+
+			It("rolls back every completed step when the space role cannot be granted", func() {
+				orgRole := &cf.Role{}
+				orgRole.GUID = "org-role-guid"
+				cfClient.On("ServiceInstanceByGuid", mock.Anything, "instance-guid").Return(svcInst, nil)
+				cfClient.On("GetSpaceByGuid", mock.Anything, "space-guid").Return(space, nil)
+				uaaClient.On("CreateUser", mock.Anything).Return(User{ID: "user-guid"}, nil)
+				cfClient.On("CreateUser", mock.Anything, "user-guid").Return(user, nil)
+				cfClient.On("AssociateOrgUserByUsername", mock.Anything, "org-guid", "binding-guid").Return(orgRole, nil)
+				cfClient.On("AssociateSpaceDeveloperByUsername", mock.Anything, "space-guid", "binding-guid").Return((*cf.Role)(nil), errors.New("space role failed"))
+				cfClient.On("DeleteRole", mock.Anything, "org-role-guid").Return(nil)
+				cfClient.On("DeleteUser", mock.Anything, "user-guid").Return(nil)
+				uaaClient.On("DeleteUser", "user-guid").Return(nil)
+
+				_, err := broker.Bind(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					brokerapi.BindDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    deployerGUID,
+					},
+				)
+				Expect(err).To(MatchError("space role failed"))
+				uaaClient.AssertExpectations(GinkgoT())
+				cfClient.AssertExpectations(GinkgoT())
+			})
+
+			It("rolls back after the request is cancelled", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				live := mock.MatchedBy(func(ctx context.Context) bool { return ctx.Err() == nil })
+				cfClient.On("ServiceInstanceByGuid", mock.Anything, "instance-guid").Return(svcInst, nil)
+				cfClient.On("GetSpaceByGuid", mock.Anything, "space-guid").Return(space, nil)
+				uaaClient.On("CreateUser", mock.Anything).Return(User{ID: "user-guid"}, nil)
+				cfClient.On("CreateUser", mock.Anything, "user-guid").Return(user, nil).Run(func(mock.Arguments) { cancel() })
+				cfClient.On("AssociateOrgUserByUsername", mock.Anything, "org-guid", "binding-guid").Return((*cf.Role)(nil), context.Canceled)
+				cfClient.On("DeleteUser", live, "user-guid").Return(nil)
+				uaaClient.On("DeleteUser", "user-guid").Return(nil)
+
+				_, err := broker.Bind(
+					ctx,
+					"instance-guid",
+					"binding-guid",
+					brokerapi.BindDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    deployerGUID,
+					},
+				)
+				Expect(err).To(MatchError(context.Canceled))
+				uaaClient.AssertExpectations(GinkgoT())
+				cfClient.AssertExpectations(GinkgoT())
+			})
+
+			It("reports steps that could not be rolled back", func() {
+				cfClient.On("ServiceInstanceByGuid", mock.Anything, "instance-guid").Return(svcInst, nil)
+				cfClient.On("GetSpaceByGuid", mock.Anything, "space-guid").Return(space, nil)
+				uaaClient.On("CreateUser", mock.Anything).Return(User{ID: "user-guid"}, nil)
+				cfClient.On("CreateUser", mock.Anything, "user-guid").Return(user, nil)
+				cfClient.On("AssociateOrgUserByUsername", mock.Anything, "org-guid", "binding-guid").Return((*cf.Role)(nil), errors.New("org role failed"))
+				cfClient.On("DeleteUser", mock.Anything, "user-guid").Return(errors.New("cf unavailable"))
+				uaaClient.On("DeleteUser", "user-guid").Return(nil)
+
+				_, err := broker.Bind(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					brokerapi.BindDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    deployerGUID,
+					},
+				)
+				Expect(err).To(MatchError("org role failed; rollback failed for: cf-user"))
+				uaaClient.AssertExpectations(GinkgoT())
+			})
+
+			It("resets the password of an identical existing user", func() {
+				developerRole := &cf.Role{Type: "space_developer"}
+				cfClient.On("ServiceInstanceByGuid", mock.Anything, "instance-guid").Return(svcInst, nil)
+				cfClient.On("GetSpaceByGuid", mock.Anything, "space-guid").Return(space, nil)
+				uaaClient.On("CreateUser", mock.Anything).Return(User{}, &UAAError{StatusCode: 409})
+				uaaClient.On("GetUser", "binding-guid").Return(User{ID: "user-guid"}, nil)
+				cfClient.On("ListOrgRolesByUser", mock.Anything, "org-guid", "user-guid").Return([]*cf.Role{{Type: "organization_user"}}, nil)
+				cfClient.On("ListSpaceRolesByUser", mock.Anything, "space-guid", "user-guid").Return([]*cf.Role{developerRole}, nil)
+				uaaClient.On("ChangeUserPassword", "user-guid", "password").Return(nil)
+
+				binding, err := broker.BindAsync(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					BindDetails{BindDetails: brokerapi.BindDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    deployerGUID,
+					}},
+					false,
+				)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(binding.AlreadyExists).To(BeTrue())
+				uaaClient.AssertExpectations(GinkgoT())
+				cfClient.AssertExpectations(GinkgoT())
+				cfClient.AssertNotCalled(GinkgoT(), "CreateUser", mock.Anything, mock.Anything)
+			})
+
+			It("rejects an existing user bound under another plan", func() {
+				auditorRole := &cf.Role{Type: "space_auditor"}
+				cfClient.On("ServiceInstanceByGuid", mock.Anything, "instance-guid").Return(svcInst, nil)
+				cfClient.On("GetSpaceByGuid", mock.Anything, "space-guid").Return(space, nil)
+				uaaClient.On("CreateUser", mock.Anything).Return(User{}, &UAAError{StatusCode: 409})
+				uaaClient.On("GetUser", "binding-guid").Return(User{ID: "user-guid"}, nil)
+				cfClient.On("ListOrgRolesByUser", mock.Anything, "org-guid", "user-guid").Return([]*cf.Role{{Type: "organization_user"}}, nil)
+				cfClient.On("ListSpaceRolesByUser", mock.Anything, "space-guid", "user-guid").Return([]*cf.Role{auditorRole}, nil)
+
+				_, err := broker.BindAsync(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					BindDetails{BindDetails: brokerapi.BindDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    deployerGUID,
+					}},
+					false,
+				)
+				Expect(err).To(Equal(brokerapi.ErrBindingAlreadyExists))
+				uaaClient.AssertNotCalled(GinkgoT(), "ChangeUserPassword", mock.Anything, mock.Anything)
+			})
+
+			It("binds asynchronously when incomplete responses are accepted", func() {
+				cfClient.On("ServiceInstanceByGuid", mock.Anything, "instance-guid").Return(svcInst, nil)
+				cfClient.On("GetSpaceByGuid", mock.Anything, "space-guid").Return(space, nil)
+				uaaClient.On("CreateUser", User{
+					UserName: "binding-guid",
+					Password: "password",
+					Emails: []Email{{
+						Value:   "fake@fake.org",
+						Primary: true,
+					}},
+				}).Return(User{ID: "user-guid"}, nil)
+				cfClient.On("CreateUser", mock.Anything, "user-guid").Return(user, nil)
+				cfClient.On("AssociateOrgUserByUsername", mock.Anything, "org-guid", "binding-guid").Return(&cf.Role{}, nil)
+				cfClient.On("AssociateSpaceDeveloperByUsername", mock.Anything, "space-guid", "binding-guid").Return(&cf.Role{}, nil)
+
+				binding, err := broker.BindAsync(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					BindDetails{BindDetails: brokerapi.BindDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    deployerGUID,
+					}},
+					true,
+				)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(binding.IsAsync).To(BeTrue())
+				Expect(binding.OperationData).NotTo(BeEmpty())
+
+				Eventually(func() brokerapi.LastOperationState {
+					op, err := broker.LastBindingOperation(context.Background(), "instance-guid", "binding-guid", binding.OperationData)
+					Expect(err).NotTo(HaveOccurred())
+					return op.State
+				}).Should(Equal(brokerapi.Succeeded))
+
+				fetched, err := broker.GetBinding(context.Background(), "instance-guid", "binding-guid")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fetched.Credentials).To(Equal(map[string]interface{}{
+					"username": "binding-guid",
+					"password": "password",
+				}))
+				uaaClient.AssertExpectations(GinkgoT())
+				cfClient.AssertExpectations(GinkgoT())
+			})
+
+			It("reports a failed asynchronous bind", func() {
+				cfClient.On("ServiceInstanceByGuid", mock.Anything, "instance-guid").Return((*cf.ServiceInstance)(nil), errors.New("cf unavailable"))
+
+				binding, err := broker.BindAsync(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					BindDetails{BindDetails: brokerapi.BindDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    deployerGUID,
+					}},
+					true,
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(func() brokerapi.LastOperation {
+					op, _ := broker.LastBindingOperation(context.Background(), "instance-guid", "binding-guid", binding.OperationData)
+					return op
+				}).Should(Equal(brokerapi.LastOperation{
+					State:       brokerapi.Failed,
+					Description: "cf unavailable",
+				}))
+
+				_, err = broker.GetBinding(context.Background(), "instance-guid", "binding-guid")
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("passes the request context to the platform clients", func() {
+				ctx := context.WithValue(context.Background(), requestIdentityKey{}, "request-id")
+				fromRequest := mock.MatchedBy(func(ctx context.Context) bool {
+					return ctx.Value(requestIdentityKey{}) == "request-id"
+				})
+				cfClient.On("ServiceInstanceByGuid", fromRequest, "instance-guid").Return((*cf.ServiceInstance)(nil), errors.New("stop"))
+
+				_, err := broker.Bind(
+					ctx,
+					"instance-guid",
+					"binding-guid",
+					brokerapi.BindDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    deployerGUID,
+					},
+				)
+				Expect(err).To(MatchError("stop"))
+				cfClient.AssertExpectations(GinkgoT())
+			})
+
+			It("keeps binding in the background after the request is cancelled", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				notCancelled := mock.MatchedBy(func(ctx context.Context) bool {
+					return ctx.Err() == nil
+				})
+				cfClient.On("ServiceInstanceByGuid", notCancelled, "instance-guid").Return((*cf.ServiceInstance)(nil), errors.New("stop"))
+
+				binding, err := broker.BindAsync(
+					ctx,
+					"instance-guid",
+					"binding-guid",
+					BindDetails{BindDetails: brokerapi.BindDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    deployerGUID,
+					}},
+					true,
+				)
+				cancel()
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(func() string {
+					op, _ := broker.LastBindingOperation(context.Background(), "instance-guid", "binding-guid", binding.OperationData)
+					return op.Description
+				}).Should(Equal("stop"))
+			})
+
+			It("reports unknown operations as failed", func() {
+				op, err := broker.LastBindingOperation(context.Background(), "instance-guid", "binding-guid", "unknown")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(op.State).To(Equal(brokerapi.Failed))
+			})
+		})
+
+		Describe("update", func() {
+			It("swaps the space role of every bound user", func() {
+				binding := &cf.ServiceCredentialBinding{}
+				binding.GUID = "binding-guid"
+				cfClient.On("ServiceCredentialBindingsByInstanceGuid", mock.Anything, "instance-guid").Return([]*cf.ServiceCredentialBinding{binding}, nil)
+				uaaClient.On("GetUser", "binding-guid").Return(User{ID: "user-guid"}, nil)
+				cfClient.On("AssociateSpaceAuditorByUsername", mock.Anything, "space-guid", "binding-guid").Return(&cf.Role{}, nil)
+				orgRole := &cf.Role{Type: "organization_user"}
+				orgRole.GUID = "org-role-guid"
+				developerRole := &cf.Role{Type: "space_developer"}
+				developerRole.GUID = "developer-role-guid"
+				developerRole.Relationships.Space.Data = &cf.Relationship{GUID: "space-guid"}
+				cfClient.On("ListRolesByUser", mock.Anything, "user-guid").Return([]*cf.Role{orgRole, developerRole}, nil)
+				cfClient.On("DeleteRole", mock.Anything, "developer-role-guid").Return(nil)
+
+				_, err := broker.Update(
+					context.Background(),
+					"instance-guid",
+					brokerapi.UpdateDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    auditorGUID,
+						PreviousValues: brokerapi.PreviousValues{
+							PlanID:  deployerGUID,
+							SpaceID: "space-guid",
+							OrgID:   "org-guid",
+						},
+					},
+					false,
+				)
+				Expect(err).NotTo(HaveOccurred())
+				uaaClient.AssertExpectations(GinkgoT())
+				cfClient.AssertExpectations(GinkgoT())
+			})
+
+			It("swaps the space role in every space the binding granted it", func() {
+				binding := &cf.ServiceCredentialBinding{}
+				binding.GUID = "binding-guid"
+				cfClient.On("ServiceCredentialBindingsByInstanceGuid", mock.Anything, "instance-guid").Return([]*cf.ServiceCredentialBinding{binding}, nil)
+				uaaClient.On("GetUser", "binding-guid").Return(User{ID: "user-guid"}, nil)
+				developerRole := &cf.Role{Type: "space_developer"}
+				developerRole.GUID = "developer-role-guid"
+				developerRole.Relationships.Space.Data = &cf.Relationship{GUID: "space-guid"}
+				stagingRole := &cf.Role{Type: "space_developer"}
+				stagingRole.GUID = "staging-role-guid"
+				stagingRole.Relationships.Space.Data = &cf.Relationship{GUID: "staging-guid"}
+				cfClient.On("ListRolesByUser", mock.Anything, "user-guid").Return([]*cf.Role{developerRole, stagingRole}, nil)
+				cfClient.On("AssociateSpaceAuditorByUsername", mock.Anything, "space-guid", "binding-guid").Return(&cf.Role{}, nil)
+				cfClient.On("AssociateSpaceAuditorByUsername", mock.Anything, "staging-guid", "binding-guid").Return(&cf.Role{}, nil)
+				cfClient.On("DeleteRole", mock.Anything, "developer-role-guid").Return(nil)
+				cfClient.On("DeleteRole", mock.Anything, "staging-role-guid").Return(nil)
+
+				_, err := broker.Update(
+					context.Background(),
+					"instance-guid",
+					brokerapi.UpdateDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    auditorGUID,
+						PreviousValues: brokerapi.PreviousValues{
+							PlanID:  deployerGUID,
+							SpaceID: "space-guid",
+							OrgID:   "org-guid",
+						},
+					},
+					false,
+				)
+				Expect(err).NotTo(HaveOccurred())
+				cfClient.AssertExpectations(GinkgoT())
+			})
+
+			It("finishes an update that already partly succeeded", func() {
+				binding := &cf.ServiceCredentialBinding{}
+				binding.GUID = "binding-guid"
+				cfClient.On("ServiceCredentialBindingsByInstanceGuid", mock.Anything, "instance-guid").Return([]*cf.ServiceCredentialBinding{binding}, nil)
+				uaaClient.On("GetUser", "binding-guid").Return(User{ID: "user-guid"}, nil)
+				// The first attempt granted space_auditor in space-guid and
+				// then failed
+				developerRole := &cf.Role{Type: "space_developer"}
+				developerRole.GUID = "developer-role-guid"
+				developerRole.Relationships.Space.Data = &cf.Relationship{GUID: "space-guid"}
+				auditorRole := &cf.Role{Type: "space_auditor"}
+				auditorRole.GUID = "auditor-role-guid"
+				auditorRole.Relationships.Space.Data = &cf.Relationship{GUID: "space-guid"}
+				stagingRole := &cf.Role{Type: "space_developer"}
+				stagingRole.GUID = "staging-role-guid"
+				stagingRole.Relationships.Space.Data = &cf.Relationship{GUID: "staging-guid"}
+				cfClient.On("ListRolesByUser", mock.Anything, "user-guid").Return([]*cf.Role{developerRole, auditorRole, stagingRole}, nil)
+				cfClient.On("AssociateSpaceAuditorByUsername", mock.Anything, "staging-guid", "binding-guid").Return(&cf.Role{}, nil)
+				cfClient.On("DeleteRole", mock.Anything, "developer-role-guid").Return(nil)
+				cfClient.On("DeleteRole", mock.Anything, "staging-role-guid").Return(nil)
+
+				_, err := broker.Update(
+					context.Background(),
+					"instance-guid",
+					brokerapi.UpdateDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    auditorGUID,
+						PreviousValues: brokerapi.PreviousValues{
+							PlanID:  deployerGUID,
+							SpaceID: "space-guid",
+							OrgID:   "org-guid",
+						},
+					},
+					false,
+				)
+				Expect(err).NotTo(HaveOccurred())
+				cfClient.AssertExpectations(GinkgoT())
+				cfClient.AssertNotCalled(GinkgoT(), "AssociateSpaceAuditorByUsername", mock.Anything, "space-guid", mock.Anything)
+				cfClient.AssertNotCalled(GinkgoT(), "DeleteRole", mock.Anything, "auditor-role-guid")
+			})
+
+			It("refuses plans that the org may not use", func() {
+				plans, err := LoadPlanRegistry("config.json", []string{"org-manager"})
+				Expect(err).NotTo(HaveOccurred())
+				broker.plans = plans
+
+				_, err = broker.Update(
+					context.Background(),
+					"instance-guid",
+					brokerapi.UpdateDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    orgManagerGUID,
+						PreviousValues: brokerapi.PreviousValues{
+							PlanID:  orgAuditorGUID,
+							SpaceID: "space-guid",
+							OrgID:   "org-guid",
+						},
+					},
+					false,
+				)
+				Expect(err).To(MatchError(ContainSubstring("has not been approved by the broker's allowlist for plan org-manager")))
+				cfClient.AssertNotCalled(GinkgoT(), "ServiceCredentialBindingsByInstanceGuid", mock.Anything, mock.Anything)
+			})
+
+			It("looks up the org of instances updated without previous values", func() {
+				instance := &cf.ServiceInstance{
+					Relationships: cf.ServiceInstanceRelationships{
+						Space: &cf.ToOneRelationship{Data: &cf.Relationship{GUID: "space-guid"}},
+					},
+				}
+				space := &cf.Space{}
+				space.Relationships = &cf.SpaceRelationships{
+					Organization: &cf.ToOneRelationship{Data: &cf.Relationship{GUID: "1f7e2c3a-5b0d-4c4e-9a57-2d1e6f0b8c11"}},
+				}
+				cfClient.On("ServiceInstanceByGuid", mock.Anything, "instance-guid").Return(instance, nil)
+				cfClient.On("GetSpaceByGuid", mock.Anything, "space-guid").Return(space, nil)
+				var denylist OrgRules
+				Expect(denylist.Decode("cloud-gov-service-account:1f7e2c3a-5b0d-4c4e-9a57-2d1e6f0b8c11")).To(Succeed())
+				broker.config.OrgDenylist = denylist
+
+				_, err := broker.Update(
+					context.Background(),
+					"instance-guid",
+					brokerapi.UpdateDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    auditorGUID,
+						PreviousValues: brokerapi.PreviousValues{
+							PlanID: deployerGUID,
+						},
+					},
+					false,
+				)
+				Expect(err).To(MatchError(ContainSubstring("is on the broker's denylist")))
+			})
+
+			It("rejects plan changes for oauth clients", func() {
+				settings := &PlanSettings{Kind: PlanKindClient, GrantTypes: []string{"client_credentials"}}
+				schemas := &PlanSchemas{}
+				schemas.ServiceInstance.Create.Parameters = []byte(`{}`)
+				schemas.ServiceInstance.Update.Parameters = []byte(`{}`)
+				schemas.ServiceBinding.Create.Parameters = []byte(`{}`)
+				plans, err := NewPlanRegistry([]Service{{
+					Service: brokerapi.Service{ID: clientAccountGUID, Name: "cloud-gov-identity-provider"},
+					Plans: []Plan{
+						{ServicePlan: brokerapi.ServicePlan{ID: oauthClientGUID, Name: "oauth-client"}, Schemas: schemas, Settings: settings},
+						{ServicePlan: brokerapi.ServicePlan{ID: "other-plan-guid", Name: "other"}, Schemas: schemas, Settings: settings},
+					},
+				}})
+				Expect(err).NotTo(HaveOccurred())
+				broker.plans = plans
+
+				_, err = broker.Update(
+					context.Background(),
+					"instance-guid",
+					brokerapi.UpdateDetails{
+						ServiceID: clientAccountGUID,
+						PlanID:    "other-plan-guid",
+						PreviousValues: brokerapi.PreviousValues{
+							PlanID:  oauthClientGUID,
+							SpaceID: "space-guid",
+							OrgID:   "org-guid",
+						},
+					},
+					false,
+				)
+				Expect(err).To(Equal(brokerapi.ErrPlanChangeNotSupported))
+			})
+
+			It("rejects plans that are not in the catalog", func() {
+				_, err := broker.Update(
+					context.Background(),
+					"instance-guid",
+					brokerapi.UpdateDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    "other-plan-guid",
+						PreviousValues: brokerapi.PreviousValues{
+							PlanID: deployerGUID,
+						},
+					},
+					false,
+				)
+				Expect(err).To(MatchError(ContainSubstring("not found in catalog")))
+			})
+		})
+
+		Describe("deprovision", func() {
+			It("returns a deprovision service spec", func() {
+				uaaClient.On("GetUser", "binding-guid").Return(User{ID: "user-guid"}, nil)
+				uaaClient.On("DeleteUser", "user-guid").Return(nil)
+				cfClient.On("ListRolesByUser", mock.Anything, "user-guid").Return([]*cf.Role{}, nil)
+				cfClient.On("DeleteUser", mock.Anything, "user-guid").Return(nil)
+
+				err := broker.Unbind(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					brokerapi.UnbindDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    deployerGUID,
+					},
+				)
+				Expect(err).NotTo(HaveOccurred())
+				uaaClient.AssertExpectations(GinkgoT())
+				cfClient.AssertExpectations(GinkgoT())
+			})
+
+			It("removes the roles granted in every space before the org role", func() {
+				orgRole := &cf.Role{Type: "organization_user"}
+				orgRole.GUID = "org-role-guid"
+				stagingRole := &cf.Role{Type: "space_developer"}
+				stagingRole.GUID = "staging-role-guid"
+				stagingRole.Relationships.Space.Data = &cf.Relationship{GUID: "staging-guid"}
+				prodRole := &cf.Role{Type: "space_developer"}
+				prodRole.GUID = "prod-role-guid"
+				prodRole.Relationships.Space.Data = &cf.Relationship{GUID: "prod-guid"}
+
+				deleted := []string{}
+				uaaClient.On("GetUser", "binding-guid").Return(User{ID: "user-guid"}, nil)
+				uaaClient.On("DeleteUser", "user-guid").Return(nil)
+				cfClient.On("ListRolesByUser", mock.Anything, "user-guid").Return([]*cf.Role{orgRole, stagingRole, prodRole}, nil)
+				cfClient.On("DeleteRole", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+					deleted = append(deleted, args.String(1))
+				})
+				cfClient.On("DeleteUser", mock.Anything, "user-guid").Return(nil)
+
+				err := broker.Unbind(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					brokerapi.UnbindDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    deployerGUID,
+					},
+				)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(deleted).To(Equal([]string{"staging-role-guid", "prod-role-guid", "org-role-guid"}))
+				cfClient.AssertExpectations(GinkgoT())
+			})
+
+			It("does not return an error when the user no longer exists", func() {
+				uaaClient.On("GetUser", "binding-guid").Return(User{}, &UAAError{StatusCode: 404})
+
+				err := broker.Unbind(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					brokerapi.UnbindDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    deployerGUID,
+					},
+				)
+				Expect(err).NotTo(HaveOccurred())
+				uaaClient.AssertNotCalled(GinkgoT(), "DeleteUser", mock.Anything)
+			})
+
+			It("returns other errors looking up the user", func() {
+				uaaClient.On("GetUser", "binding-guid").Return(User{}, &UAAError{StatusCode: 401})
+
+				err := broker.Unbind(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					brokerapi.UnbindDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    deployerGUID,
+					},
+				)
+				Expect(errors.Is(err, ErrUAAUnauthorized)).To(BeTrue())
+			})
+
+			It("unbinds asynchronously when incomplete responses are accepted", func() {
+				uaaClient.On("GetUser", "binding-guid").Return(User{ID: "user-guid"}, nil)
+				uaaClient.On("DeleteUser", "user-guid").Return(nil)
+				cfClient.On("ListRolesByUser", mock.Anything, "user-guid").Return([]*cf.Role{}, nil)
+				cfClient.On("DeleteUser", mock.Anything, "user-guid").Return(nil)
+
+				spec, err := broker.UnbindAsync(
+					context.Background(),
+					"instance-guid",
+					"binding-guid",
+					brokerapi.UnbindDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    deployerGUID,
+					},
+					true,
+				)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(spec.IsAsync).To(BeTrue())
+
+				Eventually(func() brokerapi.LastOperationState {
+					op, _ := broker.LastBindingOperation(context.Background(), "instance-guid", "binding-guid", spec.OperationData)
+					return op.State
+				}).Should(Equal(brokerapi.Succeeded))
+				uaaClient.AssertExpectations(GinkgoT())
+				cfClient.AssertExpectations(GinkgoT())
+			})
+		})
+	})
+
+	Describe("org policy", func() {
+		var denylist OrgRules
+
+		BeforeEach(func() {
+			Expect(denylist.Decode("cloud-gov-service-account:1f7e2c3a-5b0d-4c4e-9a57-2d1e6f0b8c11, cloud-gov-identity-provider:sandbox-org")).To(Succeed())
+			broker.config.OrgDenylist = denylist
+		})
+
+		It("refuses to provision a denied org", func() {
+			_, err := broker.Provision(
+				context.Background(),
+				"instance-guid",
+				brokerapi.ProvisionDetails{
+					ServiceID:        userAccountGUID,
+					PlanID:           deployerGUID,
+					OrganizationGUID: "1f7e2c3a-5b0d-4c4e-9a57-2d1e6f0b8c11",
+					SpaceGUID:        "space-guid",
+				},
+				false,
+			)
+			Expect(err).To(MatchError(ContainSubstring("Organization 1f7e2c3a-5b0d-4c4e-9a57-2d1e6f0b8c11 is on the broker's denylist for plan space-deployer of service cloud-gov-service-account")))
+			cfClient.AssertNotCalled(GinkgoT(), "GetOrganizationByGuid", mock.Anything, mock.Anything)
+		})
+
+		It("provisions other orgs", func() {
+			_, err := broker.Provision(
+				context.Background(),
+				"instance-guid",
+				brokerapi.ProvisionDetails{
+					ServiceID:        userAccountGUID,
+					PlanID:           deployerGUID,
+					OrganizationGUID: "org-guid",
+					SpaceGUID:        "space-guid",
+				},
+				false,
+			)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("refuses to bind a service account in a denied org", func() {
+			_, err := broker.BindAsync(
+				context.Background(),
+				"instance-guid",
+				"binding-guid",
+				BindDetails{
+					BindDetails: brokerapi.BindDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    deployerGUID,
+					},
+					RawContext: []byte(`{"organization_guid":"1f7e2c3a-5b0d-4c4e-9a57-2d1e6f0b8c11","space_guid":"space-guid"}`),
+				},
+				false,
+			)
+			Expect(err).To(MatchError(ContainSubstring("is on the broker's denylist")))
+			uaaClient.AssertNotCalled(GinkgoT(), "CreateUser", mock.Anything)
+		})
+
+		It("looks up the org name to refuse a client bind", func() {
+			cfClient.On("GetOrganizationByGuid", mock.Anything, "org-guid").Return(&cf.Organization{Name: "sandbox-org"}, nil)
+
+			_, err := broker.BindAsync(
+				context.Background(),
+				"instance-guid",
+				"binding-guid",
+				BindDetails{
+					BindDetails: brokerapi.BindDetails{
+						ServiceID:     clientAccountGUID,
+						PlanID:        oauthClientGUID,
+						RawParameters: []byte(`{"redirect_uri": ["https://cloud.gov"]}`),
+					},
+					RawContext: []byte(`{"organization_guid":"org-guid","space_guid":"space-guid"}`),
+				},
+				false,
+			)
+			Expect(err).To(MatchError(ContainSubstring("Organization sandbox-org is on the broker's denylist")))
+			cfClient.AssertExpectations(GinkgoT())
+			uaaClient.AssertNotCalled(GinkgoT(), "CreateClient", mock.Anything)
+		})
+	})
+
+	Describe("org plans", func() {
+		orgContext := []byte(`{"organization_guid":"7c1d0b9e-3a4f-4e2b-8d6c-5f0a9e2b1c34","space_guid":"space-guid"}`)
+
+		bindOrgPlan := func(planID string) error {
+			_, err := broker.BindAsync(
+				context.Background(),
+				"instance-guid",
+				"binding-guid",
+				BindDetails{
+					BindDetails: brokerapi.BindDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    planID,
+					},
+					RawContext:          orgContext,
+					OriginatingIdentity: requesterIdentity,
+				},
+				false,
+			)
+			return err
+		}
+
+		holds := func(roles ...string) {
+			cfClient.On("ListOrgRolesByUser", mock.Anything, "7c1d0b9e-3a4f-4e2b-8d6c-5f0a9e2b1c34", "requester-guid").Return(cfRoles(roles...), nil)
+		}
+
+		BeforeEach(func() {
+			plans, err := LoadPlanRegistry("config.json", []string{"org-manager"})
+			Expect(err).NotTo(HaveOccurred())
+			broker.plans = plans
+		})
+
+		It("binds an org auditor without space roles", func() {
+			holds("organization_user", "organization_auditor")
+			uaaClient.On("CreateUser", mock.Anything).Return(User{ID: "user-guid"}, nil)
+			cfClient.On("CreateUser", mock.Anything, "user-guid").Return(&cf.User{}, nil)
+			cfClient.On("AssociateOrgUserByUsername", mock.Anything, "7c1d0b9e-3a4f-4e2b-8d6c-5f0a9e2b1c34", "binding-guid").Return(&cf.Role{}, nil)
+			cfClient.On("AssociateOrgAuditorByUsername", mock.Anything, "7c1d0b9e-3a4f-4e2b-8d6c-5f0a9e2b1c34", "binding-guid").Return(&cf.Role{}, nil)
+
+			Expect(bindOrgPlan(orgAuditorGUID)).To(Succeed())
+			cfClient.AssertExpectations(GinkgoT())
+			cfClient.AssertNotCalled(GinkgoT(), "AssociateSpaceDeveloperByUsername", mock.Anything, mock.Anything, mock.Anything)
+			cfClient.AssertNotCalled(GinkgoT(), "AssociateSpaceAuditorByUsername", mock.Anything, mock.Anything, mock.Anything)
+		})
+
+		It("refuses an org auditor to a requesting user who is not an org auditor", func() {
+			holds("organization_user")
+
+			err := bindOrgPlan(orgAuditorGUID)
+			Expect(err).To(MatchError("Requesting user does not hold roles organization_auditor in org 7c1d0b9e-3a4f-4e2b-8d6c-5f0a9e2b1c34"))
+			Expect(failureStatus(err)).To(Equal(http.StatusForbidden))
+			uaaClient.AssertNotCalled(GinkgoT(), "CreateUser", mock.Anything)
+		})
+
+		It("refuses an org manager to orgs not allowlisted for the plan", func() {
+			var allowlist OrgRules
+			Expect(allowlist.Decode("cloud-gov-service-account:7c1d0b9e-3a4f-4e2b-8d6c-5f0a9e2b1c34")).To(Succeed())
+			broker.config.OrgAllowlist = allowlist
+
+			err := bindOrgPlan(orgManagerGUID)
+			Expect(err).To(MatchError(ContainSubstring("has not been approved by the broker's allowlist for plan org-manager")))
+			uaaClient.AssertNotCalled(GinkgoT(), "CreateUser", mock.Anything)
+		})
+
+		It("binds an org manager in an org allowlisted for the plan", func() {
+			var allowlist OrgRules
+			Expect(allowlist.Decode("cloud-gov-service-account/org-manager:7c1d0b9e-3a4f-4e2b-8d6c-5f0a9e2b1c34")).To(Succeed())
+			broker.config.OrgAllowlist = allowlist
+			holds("organization_user", "organization_manager")
+			uaaClient.On("CreateUser", mock.Anything).Return(User{ID: "user-guid"}, nil)
+			cfClient.On("CreateUser", mock.Anything, "user-guid").Return(&cf.User{}, nil)
+			cfClient.On("AssociateOrgUserByUsername", mock.Anything, "7c1d0b9e-3a4f-4e2b-8d6c-5f0a9e2b1c34", "binding-guid").Return(&cf.Role{}, nil)
+			cfClient.On("AssociateOrgManagerByUsername", mock.Anything, "7c1d0b9e-3a4f-4e2b-8d6c-5f0a9e2b1c34", "binding-guid").Return(&cf.Role{}, nil)
+
+			Expect(bindOrgPlan(orgManagerGUID)).To(Succeed())
+			cfClient.AssertExpectations(GinkgoT())
+		})
+
+		It("refuses an org manager to a requesting user who is a space developer only", func() {
+			var allowlist OrgRules
+			Expect(allowlist.Decode("cloud-gov-service-account/org-manager:7c1d0b9e-3a4f-4e2b-8d6c-5f0a9e2b1c34")).To(Succeed())
+			broker.config.OrgAllowlist = allowlist
+			holds("organization_user")
+
+			err := bindOrgPlan(orgManagerGUID)
+			Expect(err).To(MatchError("Requesting user does not hold roles organization_manager in org 7c1d0b9e-3a4f-4e2b-8d6c-5f0a9e2b1c34"))
+			uaaClient.AssertNotCalled(GinkgoT(), "CreateUser", mock.Anything)
+		})
+
+		It("removes the org manager role if binding fails", func() {
+			var allowlist OrgRules
+			Expect(allowlist.Decode("cloud-gov-service-account/org-manager:7c1d0b9e-3a4f-4e2b-8d6c-5f0a9e2b1c34")).To(Succeed())
+			broker.config.OrgAllowlist = allowlist
+			holds("organization_user", "organization_manager")
+			userRole := &cf.Role{}
+			userRole.GUID = "user-role-guid"
+			uaaClient.On("CreateUser", mock.Anything).Return(User{ID: "user-guid"}, nil)
+			cfClient.On("CreateUser", mock.Anything, "user-guid").Return(&cf.User{}, nil)
+			cfClient.On("AssociateOrgUserByUsername", mock.Anything, "7c1d0b9e-3a4f-4e2b-8d6c-5f0a9e2b1c34", "binding-guid").Return(userRole, nil)
+			cfClient.On("AssociateOrgManagerByUsername", mock.Anything, "7c1d0b9e-3a4f-4e2b-8d6c-5f0a9e2b1c34", "binding-guid").Return((*cf.Role)(nil), errors.New("manager role failed"))
+			cfClient.On("DeleteRole", mock.Anything, "user-role-guid").Return(nil)
+			cfClient.On("DeleteUser", mock.Anything, "user-guid").Return(nil)
+			uaaClient.On("DeleteUser", "user-guid").Return(nil)
+
+			Expect(bindOrgPlan(orgManagerGUID)).To(MatchError("manager role failed"))
+			cfClient.AssertExpectations(GinkgoT())
+		})
+
+		Describe("when the org manager plan is no longer opted in to", func() {
+			BeforeEach(func() {
+				plans, err := LoadPlanRegistry("config.json", nil)
+				Expect(err).NotTo(HaveOccurred())
+				broker.plans = plans
+			})
+
+			It("refuses new bindings", func() {
+				err := bindOrgPlan(orgManagerGUID)
+				Expect(err).To(MatchError("Plan org-manager of service cloud-gov-service-account is not offered"))
+				uaaClient.AssertNotCalled(GinkgoT(), "CreateUser", mock.Anything)
+			})
+
+			It("still removes existing bindings", func() {
+				managerRole := &cf.Role{}
+				managerRole.GUID = "manager-role-guid"
+				uaaClient.On("GetUser", "binding-guid").Return(User{}, nil)
+				cfClient.On("ListRolesByUser", mock.Anything, "user-guid").Return([]*cf.Role{managerRole}, nil)
+				cfClient.On("DeleteRole", mock.Anything, "manager-role-guid").Return(nil)
+				cfClient.On("DeleteUser", mock.Anything, "user-guid").Return(nil)
+				uaaClient.On("DeleteUser", "user-guid").Return(nil)
+
+				err := broker.Unbind(context.Background(), "instance-guid", "binding-guid", brokerapi.UnbindDetails{
+					ServiceID: userAccountGUID,
+					PlanID:    orgManagerGUID,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				cfClient.AssertExpectations(GinkgoT())
+				uaaClient.AssertExpectations(GinkgoT())
+			})
+		})
+	})
+
+	Describe("multi-space bind", func() {
+		bindSpacesAs := func(identity, params string) error {
+			_, err := broker.BindAsync(
+				context.Background(),
+				"instance-guid",
+				"binding-guid",
+				BindDetails{
+					BindDetails: brokerapi.BindDetails{
+						ServiceID:     userAccountGUID,
+						PlanID:        deployerGUID,
+						RawParameters: []byte(params),
+					},
+					RawContext:          []byte(`{"organization_guid":"org-guid","space_guid":"dev-guid"}`),
+					OriginatingIdentity: identity,
+				},
+				false,
+			)
+			return err
+		}
+		bindSpaces := func(params string) error {
+			return bindSpacesAs(requesterIdentity, params)
+		}
+
+		holds := func(spaceGUID string, roles ...string) {
+			cfClient.On("ListSpaceRolesByUser", mock.Anything, spaceGUID, "requester-guid").Return(cfRoles(roles...), nil)
+		}
+
+		space := func(guid, name, orgGUID string) *cf.Space {
+			space := &cf.Space{Name: name}
+			space.GUID = guid
+			space.Relationships = &cf.SpaceRelationships{
+				Organization: &cf.ToOneRelationship{Data: &cf.Relationship{GUID: orgGUID}},
+			}
+			return space
+		}
+
+		It("grants the role in each named space of the org", func() {
+			cfClient.On("ListSpacesByName", mock.Anything, "org-guid", []string{"staging", "prod"}).Return([]*cf.Space{
+				space("prod-guid", "prod", "org-guid"),
+				space("staging-guid", "staging", "org-guid"),
+			}, nil)
+			holds("staging-guid", "space_developer")
+			holds("prod-guid", "space_manager", "space_developer")
+			uaaClient.On("CreateUser", mock.Anything).Return(User{ID: "user-guid"}, nil)
+			cfClient.On("CreateUser", mock.Anything, "user-guid").Return(&cf.User{}, nil)
+			cfClient.On("AssociateOrgUserByUsername", mock.Anything, "org-guid", "binding-guid").Return(&cf.Role{}, nil)
+			for _, spaceGUID := range []string{"dev-guid", "staging-guid", "prod-guid"} {
+				cfClient.On("AssociateSpaceDeveloperByUsername", mock.Anything, spaceGUID, "binding-guid").Return(&cf.Role{}, nil)
+			}
+
+			Expect(bindSpaces(`{"spaces": ["staging", "prod"], "role": "space_developer"}`)).To(Succeed())
+			cfClient.AssertExpectations(GinkgoT())
+		})
+
+		It("looks up spaces given by GUID", func() {
+			cfClient.On("GetSpaceByGuid", mock.Anything, "3b9d2f4e-6a1c-4d8b-9e0f-7c5a2b1d4e63").Return(space("3b9d2f4e-6a1c-4d8b-9e0f-7c5a2b1d4e63", "staging", "org-guid"), nil)
+			holds("3b9d2f4e-6a1c-4d8b-9e0f-7c5a2b1d4e63", "space_developer")
+			uaaClient.On("CreateUser", mock.Anything).Return(User{ID: "user-guid"}, nil)
+			cfClient.On("CreateUser", mock.Anything, "user-guid").Return(&cf.User{}, nil)
+			cfClient.On("AssociateOrgUserByUsername", mock.Anything, "org-guid", "binding-guid").Return(&cf.Role{}, nil)
+			cfClient.On("AssociateSpaceDeveloperByUsername", mock.Anything, "dev-guid", "binding-guid").Return(&cf.Role{}, nil)
+			cfClient.On("AssociateSpaceDeveloperByUsername", mock.Anything, "3b9d2f4e-6a1c-4d8b-9e0f-7c5a2b1d4e63", "binding-guid").Return(&cf.Role{}, nil)
+
+			Expect(bindSpaces(`{"spaces": ["3b9d2f4e-6a1c-4d8b-9e0f-7c5a2b1d4e63"]}`)).To(Succeed())
+			cfClient.AssertExpectations(GinkgoT())
+			cfClient.AssertNotCalled(GinkgoT(), "ListSpacesByName", mock.Anything, mock.Anything, mock.Anything)
+		})
+
+		It("refuses spaces in another org", func() {
+			cfClient.On("GetSpaceByGuid", mock.Anything, "3b9d2f4e-6a1c-4d8b-9e0f-7c5a2b1d4e63").Return(space("3b9d2f4e-6a1c-4d8b-9e0f-7c5a2b1d4e63", "staging", "other-org-guid"), nil)
+
+			err := bindSpaces(`{"spaces": ["3b9d2f4e-6a1c-4d8b-9e0f-7c5a2b1d4e63"]}`)
+			Expect(err).To(MatchError("Space 3b9d2f4e-6a1c-4d8b-9e0f-7c5a2b1d4e63 is not in the service instance's organization"))
+			uaaClient.AssertNotCalled(GinkgoT(), "CreateUser", mock.Anything)
+		})
+
+		It("refuses space names not found in the org", func() {
+			cfClient.On("ListSpacesByName", mock.Anything, "org-guid", []string{"staging", "prod"}).Return([]*cf.Space{
+				space("staging-guid", "staging", "org-guid"),
+			}, nil)
+
+			err := bindSpaces(`{"spaces": ["staging", "prod"]}`)
+			Expect(err).To(MatchError("Space prod not found in the service instance's organization"))
+			uaaClient.AssertNotCalled(GinkgoT(), "CreateUser", mock.Anything)
+		})
+
+		It("refuses spaces where the requesting user lacks the role", func() {
+			cfClient.On("ListSpacesByName", mock.Anything, "org-guid", []string{"staging", "prod"}).Return([]*cf.Space{
+				space("staging-guid", "staging", "org-guid"),
+				space("prod-guid", "prod", "org-guid"),
+			}, nil)
+			holds("staging-guid", "space_developer")
+			holds("prod-guid", "space_auditor")
+
+			err := bindSpaces(`{"spaces": ["staging", "prod"]}`)
+			Expect(err).To(MatchError("Requesting user does not hold roles space_developer in space prod-guid"))
+			uaaClient.AssertNotCalled(GinkgoT(), "CreateUser", mock.Anything)
+		})
+
+		It("refuses other spaces when the platform does not identify the requesting user", func() {
+			cfClient.On("ListSpacesByName", mock.Anything, "org-guid", []string{"staging"}).Return([]*cf.Space{
+				space("staging-guid", "staging", "org-guid"),
+			}, nil)
+
+			err := bindSpacesAs("", `{"spaces": ["staging"]}`)
+			Expect(err).To(MatchError(ContainSubstring("requires the platform to identify the requesting user")))
+			cfClient.AssertNotCalled(GinkgoT(), "ListSpaceRolesByUser", mock.Anything, mock.Anything, mock.Anything)
+			uaaClient.AssertNotCalled(GinkgoT(), "CreateUser", mock.Anything)
+		})
+
+		It("refuses roles that the plan does not grant", func() {
+			err := bindSpaces(`{"spaces": ["staging"], "role": "space_manager"}`)
+			Expect(err).To(MatchError(ContainSubstring("role")))
+			cfClient.AssertNotCalled(GinkgoT(), "ListSpacesByName", mock.Anything, mock.Anything, mock.Anything)
+		})
+
+		It("removes the roles already granted if a space fails", func() {
+			stagingRole := &cf.Role{}
+			stagingRole.GUID = "staging-role-guid"
+			cfClient.On("ListSpacesByName", mock.Anything, "org-guid", []string{"staging", "prod"}).Return([]*cf.Space{
+				space("staging-guid", "staging", "org-guid"),
+				space("prod-guid", "prod", "org-guid"),
+			}, nil)
+			holds("staging-guid", "space_developer")
+			holds("prod-guid", "space_developer")
+			uaaClient.On("CreateUser", mock.Anything).Return(User{ID: "user-guid"}, nil)
+			cfClient.On("CreateUser", mock.Anything, "user-guid").Return(&cf.User{}, nil)
+			cfClient.On("AssociateOrgUserByUsername", mock.Anything, "org-guid", "binding-guid").Return(&cf.Role{}, nil)
+			cfClient.On("AssociateSpaceDeveloperByUsername", mock.Anything, "dev-guid", "binding-guid").Return(&cf.Role{}, nil)
+			cfClient.On("AssociateSpaceDeveloperByUsername", mock.Anything, "staging-guid", "binding-guid").Return(stagingRole, nil)
+			cfClient.On("AssociateSpaceDeveloperByUsername", mock.Anything, "prod-guid", "binding-guid").Return((*cf.Role)(nil), errors.New("prod role failed"))
+			cfClient.On("DeleteRole", mock.Anything, mock.Anything).Return(nil)
+			cfClient.On("DeleteUser", mock.Anything, "user-guid").Return(nil)
+			uaaClient.On("DeleteUser", "user-guid").Return(nil)
+
+			Expect(bindSpaces(`{"spaces": ["staging", "prod"]}`)).To(MatchError("prod role failed"))
+			cfClient.AssertCalled(GinkgoT(), "DeleteRole", mock.Anything, "staging-role-guid")
+		})
+	})
+
+	Describe("requested roles", func() {
+		bindRoles := func(params string) (Binding, error) {
+			return broker.BindAsync(
+				context.Background(),
+				"instance-guid",
+				"binding-guid",
+				BindDetails{
+					BindDetails: brokerapi.BindDetails{
+						ServiceID:     userAccountGUID,
+						PlanID:        spaceRolesGUID,
+						RawParameters: []byte(params),
+					},
+					RawContext: []byte(`{"organization_guid":"org-guid","space_guid":"space-guid"}`),
+				},
+				false,
+			)
+		}
+
+		It("grants each requested role and lists them in the credentials", func() {
+			uaaClient.On("CreateUser", mock.Anything).Return(User{ID: "user-guid"}, nil)
+			cfClient.On("CreateUser", mock.Anything, "user-guid").Return(&cf.User{}, nil)
+			cfClient.On("AssociateOrgUserByUsername", mock.Anything, "org-guid", "binding-guid").Return(&cf.Role{}, nil)
+			cfClient.On("AssociateSpaceDeveloperByUsername", mock.Anything, "space-guid", "binding-guid").Return(&cf.Role{}, nil)
+			cfClient.On("AssociateSpaceSupporterByUsername", mock.Anything, "space-guid", "binding-guid").Return(&cf.Role{}, nil)
+
+			binding, err := bindRoles(`{"roles": ["space_developer", "space_supporter"]}`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Credentials).To(HaveKeyWithValue("roles", []string{"space_developer", "space_supporter"}))
+			cfClient.AssertExpectations(GinkgoT())
+			cfClient.AssertNotCalled(GinkgoT(), "AssociateSpaceAuditorByUsername", mock.Anything, mock.Anything, mock.Anything)
+		})
+
+		It("requires roles", func() {
+			_, err := bindRoles(`{}`)
+			Expect(err).To(MatchError(ContainSubstring("roles")))
+			uaaClient.AssertNotCalled(GinkgoT(), "CreateUser", mock.Anything)
+		})
+
+		It("refuses roles that the plan does not offer", func() {
+			_, err := bindRoles(`{"roles": ["space_developer", "space_admin"]}`)
+			Expect(err).To(MatchError(ContainSubstring("/roles/1")))
+			uaaClient.AssertNotCalled(GinkgoT(), "CreateUser", mock.Anything)
+		})
+
+		It("refuses roles beyond the plan's own on other plans", func() {
+			_, err := broker.BindAsync(
+				context.Background(),
+				"instance-guid",
+				"binding-guid",
+				BindDetails{
+					BindDetails: brokerapi.BindDetails{
+						ServiceID:     userAccountGUID,
+						PlanID:        auditorGUID,
+						RawParameters: []byte(`{"roles": ["space_developer"]}`),
+					},
+					RawContext: []byte(`{"organization_guid":"org-guid","space_guid":"space-guid"}`),
+				},
+				false,
+			)
+			Expect(err).To(MatchError(ContainSubstring("/roles: unknown parameter")))
+		})
+
+		It("does not switch plans of requested roles", func() {
+			_, err := broker.Update(
+				context.Background(),
+				"instance-guid",
+				brokerapi.UpdateDetails{
+					ServiceID: userAccountGUID,
+					PlanID:    deployerGUID,
+					PreviousValues: brokerapi.PreviousValues{
+						PlanID:  spaceRolesGUID,
+						SpaceID: "space-guid",
+						OrgID:   "org-guid",
+					},
+				},
+				false,
+			)
+			Expect(err).To(Equal(brokerapi.ErrPlanChangeNotSupported))
+		})
+	})
+
+	Describe("client credentials service account", func() {
+		bindClientUser := func() (Binding, error) {
+			return broker.BindAsync(
+				context.Background(),
+				"instance-guid",
+				"binding-guid",
+				BindDetails{
+					BindDetails: brokerapi.BindDetails{
+						ServiceID: userAccountGUID,
+						PlanID:    deployerClientGUID,
+					},
+					RawContext: []byte(`{"organization_guid":"org-guid","space_guid":"space-guid"}`),
+				},
+				false,
+			)
+		}
+
+		It("creates a client that CF knows as a user with the plan's roles", func() {
+			uaaClient.On("CreateClient", Client{
+				ID:                   "binding-guid",
+				ClientSecret:         "password",
+				AuthorizedGrantTypes: []string{"client_credentials"},
+				Authorities:          []string{"cloud_controller.read", "cloud_controller.write"},
+				AccessTokenValidity:  600,
+			}).Return(Client{}, nil)
+			cfClient.On("CreateUser", mock.Anything, "binding-guid").Return(&cf.User{}, nil)
+			cfClient.On("AssociateOrgRoleByUserGuid", mock.Anything, "org-guid", "binding-guid", cf.OrganizationRoleUser).Return(&cf.Role{}, nil)
+			cfClient.On("AssociateSpaceRoleByUserGuid", mock.Anything, "space-guid", "binding-guid", cf.SpaceRoleDeveloper).Return(&cf.Role{}, nil)
+
+			binding, err := bindClientUser()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Credentials).To(Equal(map[string]interface{}{
+				"client_id":     "binding-guid",
+				"client_secret": "password",
+			}))
+			uaaClient.AssertExpectations(GinkgoT())
+			cfClient.AssertExpectations(GinkgoT())
+		})
+
+		It("deletes the client if CF refuses the user", func() {
+			uaaClient.On("CreateClient", mock.Anything).Return(Client{}, nil)
+			cfClient.On("CreateUser", mock.Anything, "binding-guid").Return((*cf.User)(nil), errors.New("cf unavailable"))
+			uaaClient.On("DeleteClient", "binding-guid").Return(nil)
+
+			_, err := bindClientUser()
+			Expect(err).To(MatchError("cf unavailable"))
+			uaaClient.AssertExpectations(GinkgoT())
+		})
+
+		It("resets the secret of an identical existing client", func() {
+			uaaClient.On("CreateClient", mock.Anything).Return(Client{}, &UAAError{StatusCode: 409})
+			cfClient.On("ListOrgRolesByUser", mock.Anything, "org-guid", "binding-guid").Return([]*cf.Role{{Type: "organization_user"}}, nil)
+			cfClient.On("ListSpaceRolesByUser", mock.Anything, "space-guid", "binding-guid").Return([]*cf.Role{{Type: "space_developer"}}, nil)
+			uaaClient.On("ChangeClientSecret", "binding-guid", "password").Return(nil)
+
+			binding, err := bindClientUser()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.AlreadyExists).To(BeTrue())
+			uaaClient.AssertExpectations(GinkgoT())
+			cfClient.AssertNotCalled(GinkgoT(), "CreateUser", mock.Anything, mock.Anything)
+		})
+
+		It("removes the roles, the CF user and the client on unbind", func() {
+			spaceRole := &cf.Role{Type: "space_developer"}
+			spaceRole.GUID = "space-role-guid"
+			spaceRole.Relationships.Space.Data = &cf.Relationship{GUID: "space-guid"}
+			uaaClient.On("GetClient", "binding-guid").Return(Client{ID: "binding-guid"}, nil)
+			cfClient.On("ListRolesByUser", mock.Anything, "binding-guid").Return([]*cf.Role{spaceRole}, nil)
+			cfClient.On("DeleteRole", mock.Anything, "space-role-guid").Return(nil)
+			cfClient.On("DeleteUser", mock.Anything, "binding-guid").Return(nil)
+			uaaClient.On("DeleteClient", "binding-guid").Return(nil)
+
+			err := broker.Unbind(
+				context.Background(),
+				"instance-guid",
+				"binding-guid",
+				brokerapi.UnbindDetails{
+					ServiceID: userAccountGUID,
+					PlanID:    deployerClientGUID,
+				},
+			)
+			Expect(err).NotTo(HaveOccurred())
+			uaaClient.AssertExpectations(GinkgoT())
+			cfClient.AssertExpectations(GinkgoT())
+		})
+
+		It("does not return an error when the client no longer exists", func() {
+			uaaClient.On("GetClient", "binding-guid").Return(Client{}, &UAAError{StatusCode: 404})
+
+			err := broker.Unbind(
+				context.Background(),
+				"instance-guid",
+				"binding-guid",
+				brokerapi.UnbindDetails{
+					ServiceID: userAccountGUID,
+					PlanID:    deployerClientGUID,
+				},
+			)
+			Expect(err).NotTo(HaveOccurred())
+			cfClient.AssertNotCalled(GinkgoT(), "DeleteUser", mock.Anything, mock.Anything)
+		})
+	})
+
+	Describe("binding rotation", func() {
+		const stagingGUID = "3b9d2f4e-6a1c-4d8b-9e0f-7c5a2b1d4e63"
+
+		rotate := func(serviceID, planID string) (Binding, error) {
+			return broker.BindAsync(
+				context.Background(),
+				"instance-guid",
+				"binding-guid",
+				BindDetails{
+					BindDetails: brokerapi.BindDetails{
+						ServiceID: serviceID,
+						PlanID:    planID,
+					},
+					RawContext:           []byte(`{"organization_guid":"org-guid","space_guid":"dev-guid"}`),
+					PredecessorBindingID: "old-binding-guid",
+				},
+				false,
+			)
+		}
+
+		predecessorRoles := func(orgGUID string) []*cf.Role {
+			orgRole := &cf.Role{Type: "organization_user"}
+			orgRole.Relationships.Org.Data = &cf.Relationship{GUID: orgGUID}
+			roles := []*cf.Role{}
+			for _, spaceGUID := range []string{"dev-guid", stagingGUID} {
+				spaceRole := &cf.Role{Type: "space_developer"}
+				spaceRole.Relationships.Space.Data = &cf.Relationship{GUID: spaceGUID}
+				roles = append(roles, spaceRole)
+			}
+			return append(roles, orgRole)
+		}
+
+		BeforeEach(func() {
+			cfClient.On("ServiceInstanceByGuid", mock.Anything, "instance-guid").Return(&cf.ServiceInstance{}, nil).Maybe()
+			predecessor := &cf.ServiceCredentialBinding{}
+			predecessor.GUID = "old-binding-guid"
+			cfClient.On("ServiceCredentialBindingsByInstanceGuid", mock.Anything, "instance-guid").Return([]*cf.ServiceCredentialBinding{predecessor}, nil).Maybe()
+			staging := &cf.Space{Name: "staging"}
+			staging.GUID = stagingGUID
+			staging.Relationships = &cf.SpaceRelationships{
+				Organization: &cf.ToOneRelationship{Data: &cf.Relationship{GUID: "org-guid"}},
+			}
+			cfClient.On("GetSpaceByGuid", mock.Anything, stagingGUID).Return(staging, nil).Maybe()
+		})
+
+		It("copies the configuration of the predecessor client", func() {
+			uaaClient.On("GetClient", "old-binding-guid").Return(Client{
+				ID:          "old-binding-guid",
+				Scope:       []string{"openid"},
+				RedirectURI: []string{"https://app.cloud.gov/auth"},
+				AllowPublic: true,
+			}, nil)
+			uaaClient.On("CreateClient", Client{
+				ID:                   "binding-guid",
+				AuthorizedGrantTypes: []string{"authorization_code", "refresh_token"},
+				Scope:                []string{"openid"},
+				RedirectURI:          []string{"https://app.cloud.gov/auth"},
+				ClientSecret:         "password",
+				AccessTokenValidity:  600,
+				RefreshTokenValidity: 86400,
+				AllowPublic:          true,
+			}).Return(Client{}, nil)
+
+			binding, err := rotate(clientAccountGUID, oauthClientGUID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Credentials).To(HaveKeyWithValue("client_id", "binding-guid"))
+			Expect(binding.Credentials).NotTo(HaveKey("client_secret"))
+			uaaClient.AssertExpectations(GinkgoT())
+			uaaClient.AssertNotCalled(GinkgoT(), "DeleteClient", mock.Anything)
+		})
+
+		It("copies a predecessor client that UAA reports without scopes or authorities", func() {
+			uaaClient.On("GetClient", "old-binding-guid").Return(Client{
+				ID:                   "old-binding-guid",
+				AuthorizedGrantTypes: []string{"client_credentials"},
+				Scope:                []string{"uaa.none"},
+				Authorities:          []string{"uaa.none"},
+			}, nil)
+			uaaClient.On("CreateClient", Client{
+				ID:                   "binding-guid",
+				AuthorizedGrantTypes: []string{"client_credentials"},
+				Scope:                []string{"openid"},
+				ClientSecret:         "password",
+				AccessTokenValidity:  600,
+				RefreshTokenValidity: 86400,
+			}).Return(Client{}, nil)
+
+			_, err := rotate(clientAccountGUID, oauthClientGUID)
+			Expect(err).NotTo(HaveOccurred())
+			uaaClient.AssertExpectations(GinkgoT())
+		})
+
+		It("refuses a predecessor configuration that the plan no longer permits", func() {
+			uaaClient.On("GetClient", "old-binding-guid").Return(Client{
+				ID:                   "old-binding-guid",
+				AuthorizedGrantTypes: []string{"client_credentials"},
+				Scope:                []string{"openid"},
+				Authorities:          []string{"cloud_controller.admin"},
+			}, nil)
+
+			_, err := rotate(clientAccountGUID, oauthClientGUID)
+			Expect(err).To(MatchError("Authorities not permitted: cloud_controller.admin"))
+			Expect(failureStatus(err)).To(Equal(http.StatusBadRequest))
+			uaaClient.AssertNotCalled(GinkgoT(), "CreateClient", mock.Anything)
+		})
+
+		It("refuses a predecessor that is not a binding of the instance", func() {
+			_, err := broker.BindAsync(
+				context.Background(),
+				"instance-guid",
+				"binding-guid",
+				BindDetails{
+					BindDetails: brokerapi.BindDetails{
+						ServiceID: clientAccountGUID,
+						PlanID:    oauthClientGUID,
+					},
+					PredecessorBindingID: "other-instance-binding-guid",
+				},
+				false,
+			)
+			Expect(err).To(MatchError("Predecessor binding other-instance-binding-guid not found"))
+			uaaClient.AssertNotCalled(GinkgoT(), "GetClient", mock.Anything)
+			uaaClient.AssertNotCalled(GinkgoT(), "CreateClient", mock.Anything)
+		})
+
+		It("refuses a predecessor that does not exist", func() {
+			uaaClient.On("GetClient", "old-binding-guid").Return(Client{}, &UAAError{StatusCode: 404})
+
+			_, err := rotate(clientAccountGUID, oauthClientGUID)
+			Expect(err).To(MatchError("Predecessor binding old-binding-guid not found"))
+			uaaClient.AssertNotCalled(GinkgoT(), "CreateClient", mock.Anything)
+		})
+
+		It("grants a new user the roles of the predecessor user", func() {
+			uaaClient.On("GetUser", "old-binding-guid").Return(User{}, nil)
+			cfClient.On("ListRolesByUser", mock.Anything, "user-guid").Return(predecessorRoles("org-guid"), nil)
+			uaaClient.On("CreateUser", mock.Anything).Return(User{}, nil)
+			cfClient.On("CreateUser", mock.Anything, "user-guid").Return(&cf.User{}, nil)
+			cfClient.On("AssociateOrgUserByUsername", mock.Anything, "org-guid", "binding-guid").Return(&cf.Role{}, nil)
+			cfClient.On("AssociateSpaceDeveloperByUsername", mock.Anything, "dev-guid", "binding-guid").Return(&cf.Role{}, nil)
+			cfClient.On("AssociateSpaceDeveloperByUsername", mock.Anything, stagingGUID, "binding-guid").Return(&cf.Role{}, nil)
+
+			_, err := rotate(userAccountGUID, deployerGUID)
+			Expect(err).NotTo(HaveOccurred())
+			cfClient.AssertExpectations(GinkgoT())
+			uaaClient.AssertNotCalled(GinkgoT(), "DeleteUser", mock.Anything)
+		})
+
+		It("refuses a predecessor in another org", func() {
+			uaaClient.On("GetUser", "old-binding-guid").Return(User{}, nil)
+			cfClient.On("ListRolesByUser", mock.Anything, "user-guid").Return(predecessorRoles("other-org-guid"), nil)
+
+			_, err := rotate(userAccountGUID, deployerGUID)
+			Expect(err).To(MatchError("Predecessor binding old-binding-guid is not in the service instance's organization"))
+			uaaClient.AssertNotCalled(GinkgoT(), "CreateUser", mock.Anything)
+		})
+
+		It("grants a new client the roles of the predecessor client", func() {
+			uaaClient.On("GetClient", "old-binding-guid").Return(Client{ID: "old-binding-guid"}, nil)
+			cfClient.On("ListRolesByUser", mock.Anything, "old-binding-guid").Return(predecessorRoles("org-guid"), nil)
+			uaaClient.On("CreateClient", mock.Anything).Return(Client{}, nil)
+			cfClient.On("CreateUser", mock.Anything, "binding-guid").Return(&cf.User{}, nil)
+			cfClient.On("AssociateOrgRoleByUserGuid", mock.Anything, "org-guid", "binding-guid", cf.OrganizationRoleUser).Return(&cf.Role{}, nil)
+			cfClient.On("AssociateSpaceRoleByUserGuid", mock.Anything, "dev-guid", "binding-guid", cf.SpaceRoleDeveloper).Return(&cf.Role{}, nil)
+			cfClient.On("AssociateSpaceRoleByUserGuid", mock.Anything, stagingGUID, "binding-guid", cf.SpaceRoleDeveloper).Return(&cf.Role{}, nil)
+
+			_, err := rotate(userAccountGUID, deployerClientGUID)
+			Expect(err).NotTo(HaveOccurred())
+			cfClient.AssertExpectations(GinkgoT())
+		})
+	})
+
+	Describe("provision validation", func() {
+		provision := func(serviceID, planID, params string) error {
+			_, err := broker.Provision(
+				context.Background(),
+				"instance-guid",
+				brokerapi.ProvisionDetails{
+					ServiceID:        serviceID,
+					PlanID:           planID,
+					OrganizationGUID: "org-guid",
+					SpaceGUID:        "space-guid",
+					RawParameters:    []byte(params),
+				},
+				false,
+			)
+			return err
+		}
+
+		It("rejects plans that are not in the catalog", func() {
+			err := provision(userAccountGUID, oauthClientGUID, "")
+			Expect(err).To(MatchError(ContainSubstring("not found in catalog")))
+		})
+
+		It("rejects parameters for service accounts", func() {
+			err := provision(userAccountGUID, deployerGUID, `{"redirect_uri": ["https://cloud.gov"]}`)
+			Expect(err).To(MatchError("Invalid parameters: /redirect_uri: unknown parameter"))
+		})
+
+		It("rejects unknown parameters for oauth clients", func() {
+			err := provision(clientAccountGUID, oauthClientGUID, `{"redirect_uris": ["https://cloud.gov"]}`)
+			Expect(err).To(MatchError("Invalid parameters: /redirect_uris: unknown parameter"))
+			uaaClient.AssertNotCalled(GinkgoT(), "CreateClient", mock.Anything)
+		})
+
+		It("rejects forbidden default scopes", func() {
+			err := provision(clientAccountGUID, oauthClientGUID, `{"scopes": ["cloud_controller.admin"]}`)
+			Expect(err).To(MatchError(ContainSubstring("Scope(s) not permitted: cloud_controller.admin")))
+			Expect(failureStatus(err)).To(Equal(http.StatusBadRequest))
+			uaaClient.AssertNotCalled(GinkgoT(), "CreateClient", mock.Anything)
+		})
+
+		It("stores defaults on a client kept for the instance", func() {
+			uaaClient.On("CreateClient", Client{
+				ID:                   "instance-guid",
+				AuthorizedGrantTypes: []string{"client_credentials"},
+				Scope:                []string{"uaa.none"},
+				Authorities:          []string{"uaa.none"},
+				ClientSecret:         "password",
+				BindDefaults: &BindOptions{
+					RedirectURI: []string{"https://cloud.gov"},
+					Scopes:      []string{"openid"},
+				},
+			}).Return(Client{}, nil)
+
+			err := provision(clientAccountGUID, oauthClientGUID, `{"redirect_uri": ["https://cloud.gov"], "scopes": ["openid"]}`)
+			Expect(err).NotTo(HaveOccurred())
+			uaaClient.AssertExpectations(GinkgoT())
+		})
+
+		It("fails to provision if the defaults cannot be stored", func() {
+			uaaClient.On("CreateClient", mock.Anything).Return(Client{}, &UAAError{StatusCode: 503})
+
+			err := provision(clientAccountGUID, oauthClientGUID, `{"redirect_uri": ["https://cloud.gov"]}`)
+			Expect(errors.Is(err, ErrUAAServerError)).To(BeTrue())
+		})
+
+		It("accepts a repeated provision with the same defaults", func() {
+			uaaClient.On("CreateClient", mock.Anything).Return(Client{}, &UAAError{StatusCode: 409})
+			uaaClient.On("GetClient", "instance-guid").Return(Client{
+				ID:           "instance-guid",
+				BindDefaults: &BindOptions{RedirectURI: []string{"https://cloud.gov"}},
+			}, nil)
+
+			Expect(provision(clientAccountGUID, oauthClientGUID, `{"redirect_uri": ["https://cloud.gov"]}`)).To(Succeed())
+			Expect(provision(clientAccountGUID, oauthClientGUID, `{"redirect_uri": ["https://other.cloud.gov"]}`)).To(Equal(brokerapi.ErrInstanceAlreadyExists))
+		})
+
+		It("rejects bind parameters that do not match the plan's schema", func() {
+			_, err := broker.Bind(
+				context.Background(),
+				"instance-guid",
+				"binding-guid",
+				brokerapi.BindDetails{
+					ServiceID:     clientAccountGUID,
+					PlanID:        oauthClientGUID,
+					RawParameters: []byte(`{"redirect_uri": "https://cloud.gov", "allowpublic": "true"}`),
+				},
+			)
+			Expect(err).To(MatchError("Invalid parameters: /allowpublic: got string, want boolean; /redirect_uri: got string, want array"))
+			uaaClient.AssertNotCalled(GinkgoT(), "CreateClient", mock.Anything)
+		})
+
+		It("rejects update parameters", func() {
+			_, err := broker.Update(
+				context.Background(),
+				"instance-guid",
+				brokerapi.UpdateDetails{
+					ServiceID:     userAccountGUID,
+					RawParameters: []byte(`{"space": "other"}`),
+					PreviousValues: brokerapi.PreviousValues{
+						PlanID: deployerGUID,
+					},
+				},
+				false,
+			)
+			Expect(err).To(MatchError("Invalid parameters: /space: unknown parameter"))
+		})
+
+		It("does not look up defaults when the bind gives every option", func() {
+			uaaClient.On("CreateClient", mock.Anything).Return(Client{}, nil)
+
+			_, err := broker.Bind(
+				context.Background(),
+				"instance-guid",
+				"binding-guid",
+				brokerapi.BindDetails{
+					ServiceID:     clientAccountGUID,
+					PlanID:        oauthClientGUID,
+					RawParameters: []byte(`{"redirect_uri": ["https://my.app.cloud.gov"], "scopes": ["openid"], "allowpublic": false}`),
+				},
+			)
+			Expect(err).NotTo(HaveOccurred())
+			uaaClient.AssertNotCalled(GinkgoT(), "GetClient", "instance-guid")
+		})
+
+		It("binds with the instance defaults", func() {
+			uaaClient.On("GetClient", "instance-guid").Return(Client{
+				ID: "instance-guid",
+				BindDefaults: &BindOptions{
+					RedirectURI: []string{"https://cloud.gov"},
+					Scopes:      []string{"openid"},
+				},
+			}, nil)
+			uaaClient.On("CreateClient", Client{
+				ID:                   "binding-guid",
+				AuthorizedGrantTypes: []string{"authorization_code", "refresh_token"},
+				Scope:                []string{"openid"},
+				RedirectURI:          []string{"https://my.app.cloud.gov"},
+				ClientSecret:         "password",
+				AccessTokenValidity:  600,
+				RefreshTokenValidity: 86400,
+			}).Return(Client{ID: "client-guid"}, nil)
+
+			_, err := broker.Bind(
+				context.Background(),
+				"instance-guid",
+				"binding-guid",
+				brokerapi.BindDetails{
+					ServiceID:     clientAccountGUID,
+					PlanID:        oauthClientGUID,
+					RawParameters: []byte(`{"redirect_uri": ["https://my.app.cloud.gov"]}`),
+				},
+			)
+			Expect(err).NotTo(HaveOccurred())
+			uaaClient.AssertExpectations(GinkgoT())
+		})
+
+		It("leaves out the redirect defaults of clients without authorization_code", func() {
+			allowPublic := true
+			uaaClient.On("GetClient", "instance-guid").Return(Client{
+				ID: "instance-guid",
+				BindDefaults: &BindOptions{
+					RedirectURI: []string{"https://cloud.gov"},
+					AllowPublic: &allowPublic,
+				},
+			}, nil)
+			uaaClient.On("CreateClient", mock.MatchedBy(func(client Client) bool {
+				return len(client.RedirectURI) == 0 && !client.AllowPublic
+			})).Return(Client{ID: "client-guid"}, nil)
+
+			_, err := broker.Bind(
+				context.Background(),
+				"instance-guid",
+				"binding-guid",
+				brokerapi.BindDetails{
+					ServiceID:     clientAccountGUID,
+					PlanID:        oauthClientGUID,
+					RawParameters: []byte(`{"grant_types": ["client_credentials"], "scopes": ["openid"]}`),
+				},
+			)
+			Expect(err).NotTo(HaveOccurred())
+			uaaClient.AssertExpectations(GinkgoT())
 		})
 	})
 })
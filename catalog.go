@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	cf "github.com/cloudfoundry/go-cfclient/v3/resource"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// Service is a brokerapi.Service with the catalog fields that the vendored
+// brokerapi does not know about.
+type Service struct {
+	brokerapi.Service
+	BindingsRetrievable bool   `json:"bindings_retrievable,omitempty"`
+	BindingRotatable    bool   `json:"binding_rotatable,omitempty"`
+	Plans               []Plan `json:"plans"`
+}
+
+// Plan is a brokerapi.ServicePlan along with the settings that tell the broker
+// what credentials to create for it. Settings are not part of the OSB catalog.
+type Plan struct {
+	brokerapi.ServicePlan
+	Schemas  *PlanSchemas  `json:"schemas,omitempty"`
+	Settings *PlanSettings `json:"broker,omitempty"`
+}
+
+// PlanKind is the kind of UAA credentials that a plan's bindings hold.
+type PlanKind string
+
+// Client user plans bind UAA clients that CF also knows as users, so that
+// they hold org and space roles but authenticate with client credentials.
+const (
+	PlanKindUser       PlanKind = "uaa-user"
+	PlanKindClient     PlanKind = "uaa-client"
+	PlanKindClientUser PlanKind = "uaa-client-user"
+)
+
+// cfUser reports whether bindings of the kind are CF users holding roles.
+func (k PlanKind) cfUser() bool {
+	return k == PlanKindUser || k == PlanKindClientUser
+}
+
+// PlanSettings declares what a plan's bindings are granted. Roles apply to
+// users and client users, authorities to client users, and scopes, grant types
+// and token validity to clients. Bindings may request RequestableRoles in place
+// of SpaceRoles, and client bindings RequestableGrantTypes in place of
+// GrantTypes along with any RequestableAuthorities. OrgScopes widens Scopes for
+// the orgs it names by GUID or name. Zero token validities fall back to the
+// broker's configuration. OptIn plans are left out of the catalog unless the
+// broker is configured to offer them, and Gated plans are limited to orgs that
+// the allowlist names for the plan.
+type PlanSettings struct {
+	Kind                   PlanKind            `json:"kind"`
+	OptIn                  bool                `json:"opt_in,omitempty"`
+	Gated                  bool                `json:"gated,omitempty"`
+	OrgRoles               []string            `json:"org_roles,omitempty"`
+	SpaceRoles             []string            `json:"space_roles,omitempty"`
+	RequestableRoles       []string            `json:"requestable_roles,omitempty"`
+	Scopes                 []string            `json:"scopes,omitempty"`
+	OrgScopes              map[string][]string `json:"org_scopes,omitempty"`
+	DefaultScopes          []string            `json:"default_scopes,omitempty"`
+	GrantTypes             []string            `json:"grant_types,omitempty"`
+	RequestableGrantTypes  []string            `json:"requestable_grant_types,omitempty"`
+	RequestableAuthorities []string            `json:"requestable_authorities,omitempty"`
+	Authorities            []string            `json:"authorities,omitempty"`
+	AccessTokenValidity    int                 `json:"access_token_validity,omitempty"`
+	RefreshTokenValidity   int                 `json:"refresh_token_validity,omitempty"`
+}
+
+var (
+	supportedOrgRoles = map[string]cf.OrganizationRoleType{
+		cf.OrganizationRoleUser.String():    cf.OrganizationRoleUser,
+		cf.OrganizationRoleAuditor.String(): cf.OrganizationRoleAuditor,
+		cf.OrganizationRoleManager.String(): cf.OrganizationRoleManager,
+	}
+	supportedGrantTypes = []string{"authorization_code", "refresh_token", "client_credentials"}
+	supportedSpaceRoles = map[string]cf.SpaceRoleType{
+		cf.SpaceRoleDeveloper.String(): cf.SpaceRoleDeveloper,
+		cf.SpaceRoleAuditor.String():   cf.SpaceRoleAuditor,
+		cf.SpaceRoleManager.String():   cf.SpaceRoleManager,
+		cf.SpaceRoleSupporter.String(): cf.SpaceRoleSupporter,
+	}
+)
+
+// RegisteredPlan is a plan's settings and compiled parameter schemas along
+// with the plan and service offering it belongs to. Withdrawn plans are left
+// out of the catalog, and may not be provisioned or bound.
+type RegisteredPlan struct {
+	PlanRef
+	PlanSettings
+	Withdrawn bool
+
+	ProvisionSchema ParameterSchema
+	UpdateSchema    ParameterSchema
+	BindSchema      ParameterSchema
+}
+
+// PlanRegistry holds the broker's catalog and indexes its plans by ID.
+type PlanRegistry struct {
+	services []Service
+	plans    map[string]RegisteredPlan
+}
+
+// LoadPlanRegistry reads the catalog from a JSON file. Opt-in plans are only
+// offered if optIn lists their name or ID.
+func LoadPlanRegistry(path string, optIn []string) (*PlanRegistry, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var services []Service
+	if err := json.Unmarshal(buf, &services); err != nil {
+		return nil, fmt.Errorf("Invalid catalog %s: %s", path, err)
+	}
+	registry, err := NewPlanRegistry(services)
+	if err != nil {
+		return nil, err
+	}
+	registry.withdrawOptInPlans(optIn)
+	return registry, nil
+}
+
+// withdrawOptInPlans stops offering the opt-in plans that optIn does not
+// list. They stay in the registry so that their existing instances and
+// bindings can still be removed.
+func (r *PlanRegistry) withdrawOptInPlans(optIn []string) {
+	enabled := map[string]bool{}
+	for _, plan := range optIn {
+		enabled[plan] = true
+	}
+
+	for id, plan := range r.plans {
+		if plan.OptIn && !enabled[plan.PlanName] && !enabled[id] {
+			plan.Withdrawn = true
+			r.plans[id] = plan
+		}
+	}
+}
+
+// NewPlanRegistry returns a registry of services, or an error if any of their
+// plans has missing or unsupported settings.
+func NewPlanRegistry(services []Service) (*PlanRegistry, error) {
+	registry := &PlanRegistry{services: services, plans: map[string]RegisteredPlan{}}
+
+	for _, service := range services {
+		for _, plan := range service.Plans {
+			if plan.Settings == nil {
+				return nil, fmt.Errorf("Plan %s of service %s has no broker settings", plan.Name, service.Name)
+			}
+			if _, ok := registry.plans[plan.ID]; ok {
+				return nil, fmt.Errorf("Plan ID %s is not unique", plan.ID)
+			}
+			if err := plan.Settings.validate(); err != nil {
+				return nil, fmt.Errorf("Plan %s of service %s: %s", plan.Name, service.Name, err)
+			}
+			if plan.Schemas == nil {
+				return nil, fmt.Errorf("Plan %s of service %s has no schemas", plan.Name, service.Name)
+			}
+
+			registered := RegisteredPlan{
+				PlanRef: PlanRef{
+					ServiceID:   service.ID,
+					ServiceName: service.Name,
+					PlanID:      plan.ID,
+					PlanName:    plan.Name,
+					Gated:       plan.Settings.Gated,
+				},
+				PlanSettings: *plan.Settings,
+			}
+
+			var err error
+			location := fmt.Sprintf("%s/%s", service.Name, plan.Name)
+			registered.ProvisionSchema, err = compileSchema(location+"/service_instance/create", plan.Schemas.ServiceInstance.Create.Parameters)
+			if err != nil {
+				return nil, err
+			}
+			registered.UpdateSchema, err = compileSchema(location+"/service_instance/update", plan.Schemas.ServiceInstance.Update.Parameters)
+			if err != nil {
+				return nil, err
+			}
+			registered.BindSchema, err = compileSchema(location+"/service_binding/create", plan.Schemas.ServiceBinding.Create.Parameters)
+			if err != nil {
+				return nil, err
+			}
+
+			registry.plans[plan.ID] = registered
+		}
+	}
+
+	return registry, nil
+}
+
+// allowedScopes returns the scopes that clients in the given org may be
+// granted. orgName may be empty if OrgScopes names no org by name.
+func (s PlanSettings) allowedScopes(orgGUID, orgName string) []string {
+	scopes := append([]string{}, s.Scopes...)
+	scopes = append(scopes, s.OrgScopes[orgGUID]...)
+	if orgName != "" {
+		scopes = append(scopes, s.OrgScopes[orgName]...)
+	}
+	return scopes
+}
+
+// orgScopesNeedName reports whether OrgScopes names an org by name rather
+// than GUID.
+func (s PlanSettings) orgScopesNeedName() bool {
+	for org := range s.OrgScopes {
+		if !guidPattern.MatchString(org) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s PlanSettings) validate() error {
+	switch s.Kind {
+	case PlanKindUser, PlanKindClientUser:
+		if s.Kind == PlanKindClientUser && len(s.Authorities) == 0 {
+			return fmt.Errorf("no authorities")
+		}
+		for _, role := range s.OrgRoles {
+			if _, ok := supportedOrgRoles[role]; !ok {
+				return fmt.Errorf("org role %s is not supported", role)
+			}
+		}
+		for _, role := range append(append([]string{}, s.SpaceRoles...), s.RequestableRoles...) {
+			if _, ok := supportedSpaceRoles[role]; !ok {
+				return fmt.Errorf("space role %s is not supported", role)
+			}
+		}
+	case PlanKindClient:
+		if len(s.GrantTypes) == 0 {
+			return fmt.Errorf("no grant types")
+		}
+		for _, grantType := range append(append([]string{}, s.GrantTypes...), s.RequestableGrantTypes...) {
+			if !containsString(supportedGrantTypes, grantType) {
+				return fmt.Errorf("grant type %s is not supported", grantType)
+			}
+		}
+		allowed := map[string]bool{}
+		for _, scope := range s.Scopes {
+			allowed[scope] = true
+		}
+		for _, scope := range s.DefaultScopes {
+			if !allowed[scope] {
+				return fmt.Errorf("default scope %s is not allowed", scope)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown kind %q", s.Kind)
+	}
+	return nil
+}
+
+// Services returns the catalog without the broker's plan settings or
+// withdrawn plans.
+func (r *PlanRegistry) Services() []Service {
+	services := []Service{}
+	for _, service := range r.services {
+		plans := []Plan{}
+		for _, plan := range service.Plans {
+			if r.plans[plan.ID].Withdrawn {
+				continue
+			}
+			plans = append(plans, Plan{ServicePlan: plan.ServicePlan, Schemas: plan.Schemas})
+		}
+		service.Plans = plans
+		services = append(services, service)
+	}
+	return services
+}
+
+// Plan returns the plan with planID, provided it belongs to serviceID.
+func (r *PlanRegistry) Plan(serviceID, planID string) (RegisteredPlan, bool) {
+	plan, ok := r.plans[planID]
+	if !ok || plan.ServiceID != serviceID {
+		return RegisteredPlan{}, false
+	}
+	return plan, true
+}
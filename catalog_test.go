@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+func TestLoadPlanRegistry(t *testing.T) {
+	registry, err := LoadPlanRegistry("config.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, ok := registry.Plan("964bd86d-72fa-4852-957f-e4cd802de34b", "074e652b-b77b-4ac3-8d5b-52144486b1a3")
+	if !ok {
+		t.Fatal("expected to find the space-deployer plan")
+	}
+	if plan.Kind != PlanKindUser || plan.PlanName != "space-deployer" || plan.ServiceName != "cloud-gov-service-account" {
+		t.Errorf("unexpected plan %+v", plan)
+	}
+
+	if _, ok := registry.Plan("6b508bb8-2af7-4a75-9efd-7b76a01d705d", "074e652b-b77b-4ac3-8d5b-52144486b1a3"); ok {
+		t.Error("expected not to find a plan under another service")
+	}
+
+	buf, err := json.Marshal(registry.Services())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(buf), `"broker"`) || strings.Contains(string(buf), `"space_roles"`) {
+		t.Errorf("expected the catalog not to include plan settings: %s", buf)
+	}
+	if !strings.Contains(string(buf), `"service_binding":{"create":{"parameters":{"$schema":"http://json-schema.org/draft-04/schema#"`) {
+		t.Errorf("expected the catalog to include parameter schemas: %s", buf)
+	}
+}
+
+func TestNewPlanRegistryRequiresSchemas(t *testing.T) {
+	schemas := &PlanSchemas{}
+	schemas.ServiceInstance.Create.Parameters = json.RawMessage(`{}`)
+	schemas.ServiceBinding.Create.Parameters = json.RawMessage(`{}`)
+
+	_, err := NewPlanRegistry([]Service{{
+		Service: brokerapi.Service{ID: "service-guid", Name: "service"},
+		Plans: []Plan{{
+			ServicePlan: brokerapi.ServicePlan{ID: "plan-guid", Name: "plan"},
+			Schemas:     schemas,
+			Settings:    &PlanSettings{Kind: PlanKindUser},
+		}},
+	}})
+	if err == nil || !strings.Contains(err.Error(), "no schema for service/plan/service_instance/update") {
+		t.Errorf("expected a missing schema error, got %v", err)
+	}
+}
+
+func TestNewPlanRegistryValidatesSettings(t *testing.T) {
+	cases := map[string]*PlanSettings{
+		"has no broker settings":               nil,
+		`unknown kind "uaa-group"`:             {Kind: "uaa-group"},
+		"space role space_admin":               {Kind: PlanKindUser, SpaceRoles: []string{"space_admin"}},
+		"no grant types":                       {Kind: PlanKindClient},
+		"grant type password is not supported": {Kind: PlanKindClient, GrantTypes: []string{"authorization_code"}, RequestableGrantTypes: []string{"password"}},
+		"no authorities":                       {Kind: PlanKindClientUser, SpaceRoles: []string{"space_developer"}},
+		"default scope openid is not allowed":  {Kind: PlanKindClient, GrantTypes: []string{"client_credentials"}, DefaultScopes: []string{"openid"}},
+	}
+
+	for expected, settings := range cases {
+		_, err := NewPlanRegistry([]Service{{
+			Service: brokerapi.Service{ID: "service-guid", Name: "service"},
+			Plans: []Plan{{
+				ServicePlan: brokerapi.ServicePlan{ID: "plan-guid", Name: "plan"},
+				Settings:    settings,
+			}},
+		}})
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected an error containing %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestLoadPlanRegistryOptInPlans(t *testing.T) {
+	serviceID := "964bd86d-72fa-4852-957f-e4cd802de34b"
+	orgManagerID := "b8d0c7e3-41a9-4f5e-8c62-7a9e1d3f0c25"
+
+	registry, err := LoadPlanRegistry("config.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, ok := registry.Plan(serviceID, orgManagerID)
+	if !ok || !plan.Withdrawn {
+		t.Error("expected the org-manager plan to be kept but withdrawn unless opted in")
+	}
+	buf, err := json.Marshal(registry.Services())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(buf), "org-manager") {
+		t.Errorf("expected the catalog not to offer the org-manager plan: %s", buf)
+	}
+
+	for _, optIn := range []string{"org-manager", orgManagerID} {
+		registry, err := LoadPlanRegistry("config.json", []string{optIn})
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, ok := registry.Plan(serviceID, orgManagerID)
+		if !ok || plan.Withdrawn {
+			t.Fatalf("expected opting in to %s to offer the org-manager plan", optIn)
+		}
+		if !plan.PlanRef.Gated {
+			t.Error("expected the org-manager plan to be gated")
+		}
+	}
+}
+
+func TestPlanSettingsAllowedScopes(t *testing.T) {
+	settings := PlanSettings{
+		Scopes: []string{"openid"},
+		OrgScopes: map[string][]string{
+			"6a1d3b2c-4e5f-4a7b-8c9d-0e1f2a3b4c5d": {"email"},
+			"trusted-org":                          {"profile"},
+		},
+	}
+
+	if !settings.orgScopesNeedName() {
+		t.Error("expected org scopes keyed by name to need the org's name")
+	}
+	scopes := settings.allowedScopes("6a1d3b2c-4e5f-4a7b-8c9d-0e1f2a3b4c5d", "trusted-org")
+	if !sameStrings(scopes, []string{"openid", "email", "profile"}) {
+		t.Errorf("unexpected scopes %v", scopes)
+	}
+	scopes = settings.allowedScopes("org-guid", "other-org")
+	if !sameStrings(scopes, []string{"openid"}) {
+		t.Errorf("unexpected scopes %v", scopes)
+	}
+}
@@ -8,67 +8,168 @@ import (
 )
 
 type PAASClient interface {
-	ServiceInstanceByGuid(guid string) (*cf.ServiceInstance, error)
-	GetSpaceByGuid(guid string) (*cf.Space, error)
-	CreateUser(guid string) (*cf.User, error)
-	DeleteUser(guid string) error
-	AssociateOrgUserByUsername(orgID, userName string) (*cf.Role, error)
-	AssociateOrgAuditorByUsername(orgID, userName string) (*cf.Role, error)
-	AssociateSpaceDeveloperByUsername(spaceID, userName string) (*cf.Role, error)
-	AssociateSpaceAuditorByUsername(spaceID, userName string) (*cf.Role, error)
+	ServiceInstanceByGuid(ctx context.Context, guid string) (*cf.ServiceInstance, error)
+	GetSpaceByGuid(ctx context.Context, guid string) (*cf.Space, error)
+	ListSpacesByName(ctx context.Context, orgID string, names []string) ([]*cf.Space, error)
+	GetOrganizationByGuid(ctx context.Context, guid string) (*cf.Organization, error)
+	ListRoutesBySpace(ctx context.Context, spaceID string) ([]*cf.Route, error)
+	ListRoutesByApp(ctx context.Context, appGUID string) ([]*cf.Route, error)
+	CreateUser(ctx context.Context, guid string) (*cf.User, error)
+	DeleteUser(ctx context.Context, guid string) error
+	AssociateOrgUserByUsername(ctx context.Context, orgID, userName string) (*cf.Role, error)
+	AssociateOrgAuditorByUsername(ctx context.Context, orgID, userName string) (*cf.Role, error)
+	AssociateOrgManagerByUsername(ctx context.Context, orgID, userName string) (*cf.Role, error)
+	AssociateSpaceDeveloperByUsername(ctx context.Context, spaceID, userName string) (*cf.Role, error)
+	AssociateSpaceAuditorByUsername(ctx context.Context, spaceID, userName string) (*cf.Role, error)
+	AssociateSpaceManagerByUsername(ctx context.Context, spaceID, userName string) (*cf.Role, error)
+	AssociateSpaceSupporterByUsername(ctx context.Context, spaceID, userName string) (*cf.Role, error)
+	ServiceCredentialBindingsByInstanceGuid(ctx context.Context, guid string) ([]*cf.ServiceCredentialBinding, error)
+	AssociateOrgRoleByUserGuid(ctx context.Context, orgID, userGUID string, roleType cf.OrganizationRoleType) (*cf.Role, error)
+	AssociateSpaceRoleByUserGuid(ctx context.Context, spaceID, userGUID string, roleType cf.SpaceRoleType) (*cf.Role, error)
+	ListRolesByUser(ctx context.Context, userGUID string) ([]*cf.Role, error)
+	ListOrgRolesByUser(ctx context.Context, orgID, userGUID string) ([]*cf.Role, error)
+	ListSpaceRolesByUser(ctx context.Context, spaceID, userGUID string) ([]*cf.Role, error)
+	DeleteRole(ctx context.Context, guid string) error
 }
 
 type CFClient struct {
 	Client *cfclient.Client
+	// PollingOptions sets how to wait for Cloud Controller jobs; nil uses
+	// the client's defaults.
+	PollingOptions *cfclient.PollingOptions
 }
 
-func (c *CFClient) ServiceInstanceByGuid(guid string) (*cf.ServiceInstance, error) {
-	svcInst, err := c.Client.ServiceInstances.Get(context.Background(), guid)
+func (c *CFClient) ServiceInstanceByGuid(ctx context.Context, guid string) (*cf.ServiceInstance, error) {
+	svcInst, err := c.Client.ServiceInstances.Get(ctx, guid)
 	return svcInst, err
 }
 
-func (c *CFClient) GetSpaceByGuid(guid string) (*cf.Space, error) {
-	space, err := c.Client.Spaces.Get(context.Background(), guid)
+func (c *CFClient) ServiceCredentialBindingsByInstanceGuid(ctx context.Context, guid string) ([]*cf.ServiceCredentialBinding, error) {
+	opts := cfclient.NewServiceCredentialBindingListOptions()
+	opts.ServiceInstanceGUIDs.EqualTo(guid)
+	bindings, err := c.Client.ServiceCredentialBindings.ListAll(ctx, opts)
+	return bindings, err
+}
+
+func (c *CFClient) GetSpaceByGuid(ctx context.Context, guid string) (*cf.Space, error) {
+	space, err := c.Client.Spaces.Get(ctx, guid)
 	return space, err
 }
 
-func (c *CFClient) GetOrganizationByGuid(guid string) (*cf.Organization, error) {
-	org, err := c.Client.Organizations.Get(context.Background(), guid)
+func (c *CFClient) ListSpacesByName(ctx context.Context, orgID string, names []string) ([]*cf.Space, error) {
+	opts := cfclient.NewSpaceListOptions()
+	opts.OrganizationGUIDs.EqualTo(orgID)
+	opts.Names.EqualTo(names...)
+	spaces, err := c.Client.Spaces.ListAll(ctx, opts)
+	return spaces, err
+}
+
+func (c *CFClient) GetOrganizationByGuid(ctx context.Context, guid string) (*cf.Organization, error) {
+	org, err := c.Client.Organizations.Get(ctx, guid)
 	return org, err
 }
 
-func (c *CFClient) CreateUser(guid string) (*cf.User, error) {
-	user, err := c.Client.Users.Create(context.Background(), &cf.UserCreate{GUID: guid})
+func (c *CFClient) ListRoutesBySpace(ctx context.Context, spaceID string) ([]*cf.Route, error) {
+	opts := cfclient.NewRouteListOptions()
+	opts.SpaceGUIDs.EqualTo(spaceID)
+	routes, err := c.Client.Routes.ListAll(ctx, opts)
+	return routes, err
+}
+
+func (c *CFClient) ListRoutesByApp(ctx context.Context, appGUID string) ([]*cf.Route, error) {
+	opts := cfclient.NewRouteListOptions()
+	opts.AppGUIDs.EqualTo(appGUID)
+	routes, err := c.Client.Routes.ListAll(ctx, opts)
+	return routes, err
+}
+
+func (c *CFClient) CreateUser(ctx context.Context, guid string) (*cf.User, error) {
+	user, err := c.Client.Users.Create(ctx, &cf.UserCreate{GUID: guid})
 	return user, err
 }
 
-func (c *CFClient) DeleteUser(guid string) error {
-	_, err := c.Client.Users.Delete(context.Background(), guid)
+func (c *CFClient) DeleteUser(ctx context.Context, guid string) error {
+	_, err := c.Client.Users.Delete(ctx, guid)
 	return err
 }
 
-func (c *CFClient) AssociateOrgUserByUsernameAndRole(orgID, userName string, roleType cf.OrganizationRoleType) (*cf.Role, error) {
-	role, err := c.Client.Roles.CreateOrganizationRoleWithUsername(context.Background(), orgID, userName, roleType, "")
+func (c *CFClient) AssociateOrgUserByUsernameAndRole(ctx context.Context, orgID, userName string, roleType cf.OrganizationRoleType) (*cf.Role, error) {
+	role, err := c.Client.Roles.CreateOrganizationRoleWithUsername(ctx, orgID, userName, roleType, "")
+	return role, err
+}
+
+func (c *CFClient) AssociateOrgUserByUsername(ctx context.Context, orgID, userName string) (*cf.Role, error) {
+	return c.AssociateOrgUserByUsernameAndRole(ctx, orgID, userName, cf.OrganizationRoleUser)
+}
+
+func (c *CFClient) AssociateOrgAuditorByUsername(ctx context.Context, orgID, userName string) (*cf.Role, error) {
+	return c.AssociateOrgUserByUsernameAndRole(ctx, orgID, userName, cf.OrganizationRoleAuditor)
+}
+
+func (c *CFClient) AssociateOrgManagerByUsername(ctx context.Context, orgID, userName string) (*cf.Role, error) {
+	return c.AssociateOrgUserByUsernameAndRole(ctx, orgID, userName, cf.OrganizationRoleManager)
+}
+
+func (c *CFClient) AssociateOrgRoleByUserGuid(ctx context.Context, orgID, userGUID string, roleType cf.OrganizationRoleType) (*cf.Role, error) {
+	role, err := c.Client.Roles.CreateOrganizationRole(ctx, orgID, userGUID, roleType)
 	return role, err
 }
 
-func (c *CFClient) AssociateOrgUserByUsername(orgID, userName string) (*cf.Role, error) {
-	return c.AssociateOrgUserByUsernameAndRole(orgID, userName, cf.OrganizationRoleUser)
+func (c *CFClient) AssociateSpaceUserByUsernameAndRole(ctx context.Context, spaceID, userName string, roleType cf.SpaceRoleType) (*cf.Role, error) {
+	role, err := c.Client.Roles.CreateSpaceRoleWithUsername(ctx, spaceID, userName, roleType, "")
+	return role, err
+}
+
+func (c *CFClient) AssociateSpaceDeveloperByUsername(ctx context.Context, spaceID, userName string) (*cf.Role, error) {
+	return c.AssociateSpaceUserByUsernameAndRole(ctx, spaceID, userName, cf.SpaceRoleDeveloper)
+}
+
+func (c *CFClient) AssociateSpaceAuditorByUsername(ctx context.Context, spaceID, userName string) (*cf.Role, error) {
+	return c.AssociateSpaceUserByUsernameAndRole(ctx, spaceID, userName, cf.SpaceRoleAuditor)
+}
+
+func (c *CFClient) AssociateSpaceManagerByUsername(ctx context.Context, spaceID, userName string) (*cf.Role, error) {
+	return c.AssociateSpaceUserByUsernameAndRole(ctx, spaceID, userName, cf.SpaceRoleManager)
 }
 
-func (c *CFClient) AssociateOrgAuditorByUsername(orgID, userName string) (*cf.Role, error) {
-	return c.AssociateOrgUserByUsernameAndRole(orgID, userName, cf.OrganizationRoleAuditor)
+func (c *CFClient) AssociateSpaceSupporterByUsername(ctx context.Context, spaceID, userName string) (*cf.Role, error) {
+	return c.AssociateSpaceUserByUsernameAndRole(ctx, spaceID, userName, cf.SpaceRoleSupporter)
 }
 
-func (c *CFClient) AssociateSpaceUserByUsernameAndRole(spaceID, userName string, roleType cf.SpaceRoleType) (*cf.Role, error) {
-	role, err := c.Client.Roles.CreateSpaceRoleWithUsername(context.Background(), spaceID, userName, roleType, "")
+func (c *CFClient) AssociateSpaceRoleByUserGuid(ctx context.Context, spaceID, userGUID string, roleType cf.SpaceRoleType) (*cf.Role, error) {
+	role, err := c.Client.Roles.CreateSpaceRole(ctx, spaceID, userGUID, roleType)
 	return role, err
 }
 
-func (c *CFClient) AssociateSpaceDeveloperByUsername(spaceID, userName string) (*cf.Role, error) {
-	return c.AssociateSpaceUserByUsernameAndRole(spaceID, userName, cf.SpaceRoleDeveloper)
+func (c *CFClient) ListRolesByUser(ctx context.Context, userGUID string) ([]*cf.Role, error) {
+	opts := cfclient.NewRoleListOptions()
+	opts.UserGUIDs.EqualTo(userGUID)
+	roles, err := c.Client.Roles.ListAll(ctx, opts)
+	return roles, err
+}
+
+func (c *CFClient) ListOrgRolesByUser(ctx context.Context, orgID, userGUID string) ([]*cf.Role, error) {
+	opts := cfclient.NewRoleListOptions()
+	opts.OrganizationGUIDs.EqualTo(orgID)
+	opts.UserGUIDs.EqualTo(userGUID)
+	roles, err := c.Client.Roles.ListAll(ctx, opts)
+	return roles, err
+}
+
+func (c *CFClient) ListSpaceRolesByUser(ctx context.Context, spaceID, userGUID string) ([]*cf.Role, error) {
+	opts := cfclient.NewRoleListOptions()
+	opts.SpaceGUIDs.EqualTo(spaceID)
+	opts.UserGUIDs.EqualTo(userGUID)
+	roles, err := c.Client.Roles.ListAll(ctx, opts)
+	return roles, err
 }
 
-func (c *CFClient) AssociateSpaceAuditorByUsername(spaceID, userName string) (*cf.Role, error) {
-	return c.AssociateSpaceUserByUsernameAndRole(spaceID, userName, cf.SpaceRoleAuditor)
+// DeleteRole removes a role and waits for Cloud Controller to finish, so that
+// roles can be removed in order.
+func (c *CFClient) DeleteRole(ctx context.Context, guid string) error {
+	jobGUID, err := c.Client.Roles.Delete(ctx, guid)
+	if err != nil || jobGUID == "" {
+		return err
+	}
+	return c.Client.Jobs.PollComplete(ctx, jobGUID, c.PollingOptions)
 }
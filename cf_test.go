@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	cfclient "github.com/cloudfoundry/go-cfclient/v3/client"
+	cfconfig "github.com/cloudfoundry/go-cfclient/v3/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("cf client", func() {
+	var (
+		server *httptest.Server
+		client *CFClient
+		state  string
+		polls  int
+	)
+
+	BeforeEach(func() {
+		state = "COMPLETE"
+		polls = 0
+
+		// Cloud Controller deletes roles in a job, which the client polls.
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			switch {
+			case r.Method == "POST" && r.URL.Path == "/oauth/token":
+				fmt.Fprint(w, `{"access_token": "token", "token_type": "bearer", "expires_in": 3600}`)
+			case r.Method == "DELETE" && r.URL.Path == "/v3/roles/role-guid":
+				w.Header().Set("Location", server.URL+"/v3/jobs/job-guid")
+				w.WriteHeader(http.StatusAccepted)
+			case r.Method == "GET" && r.URL.Path == "/v3/jobs/job-guid":
+				polls++
+				if polls == 1 {
+					fmt.Fprint(w, `{"guid": "job-guid", "operation": "role.delete", "state": "PROCESSING"}`)
+					return
+				}
+				fmt.Fprintf(w, `{"guid": "job-guid", "operation": "role.delete", "state": %q, "errors": []}`, state)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"errors": [{"code": 10000, "title": "CF-NotFound", "detail": "Unknown request"}]}`)
+			}
+		}))
+
+		config, err := cfconfig.New(server.URL,
+			cfconfig.ClientCredentials("broker", "secret"),
+			cfconfig.AuthTokenURL(server.URL, server.URL),
+		)
+		Expect(err).NotTo(HaveOccurred())
+		cfClient, err := cfclient.New(config)
+		Expect(err).NotTo(HaveOccurred())
+		polling := cfclient.NewPollingOptions()
+		polling.CheckInterval = time.Millisecond
+		client = &CFClient{Client: cfClient, PollingOptions: polling}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("DeleteRole", func() {
+		It("waits for the role to be deleted", func() {
+			Expect(client.DeleteRole(context.Background(), "role-guid")).To(Succeed())
+			Expect(polls).To(Equal(2))
+		})
+
+		It("returns an error if the deletion fails", func() {
+			state = "FAILED"
+
+			err := client.DeleteRole(context.Background(), "role-guid")
+			Expect(err).To(MatchError(ContainSubstring("received state FAILED")))
+		})
+
+		It("returns an error for roles that do not exist", func() {
+			err := client.DeleteRole(context.Background(), "other-role-guid")
+			Expect(err).To(HaveOccurred())
+			Expect(polls).To(BeZero())
+		})
+	})
+})
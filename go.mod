@@ -7,12 +7,15 @@ toolchain go1.23.4
 require (
 	code.cloudfoundry.org/lager v0.0.0-20170612214856-dfcbcba2dd4a
 	github.com/cloudfoundry/go-cfclient/v3 v3.0.0-alpha.12
+	github.com/gorilla/mux v0.0.0-20160816184630-cf79e51a62d8
 	github.com/kelseyhightower/envconfig v1.2.0
 	github.com/onsi/ginkgo v1.16.5
 	github.com/onsi/gomega v1.37.0
 	github.com/pivotal-cf/brokerapi v0.0.0-20170523133650-6d25b9398d9f
+	github.com/santhosh-tekuri/jsonschema/v6 v6.0.2
 	github.com/stretchr/testify v1.10.0
 	golang.org/x/oauth2 v0.29.0
+	golang.org/x/text v0.23.0
 )
 
 require (
@@ -23,7 +26,6 @@ require (
 	github.com/go-martini/martini v0.0.0-20170121215854-22fa46961aab // indirect
 	github.com/google/go-cmp v0.7.0 // indirect
 	github.com/gorilla/context v1.1.1 // indirect
-	github.com/gorilla/mux v0.0.0-20160816184630-cf79e51a62d8 // indirect
 	github.com/martini-contrib/render v0.0.0-20150707142108-ec18f8345a11 // indirect
 	github.com/nxadm/tail v1.4.8 // indirect
 	github.com/oxtoacart/bpool v0.0.0-20190530202638-03653db5a59c // indirect
@@ -32,7 +34,6 @@ require (
 	github.com/stretchr/objx v0.5.2 // indirect
 	golang.org/x/net v0.38.0 // indirect
 	golang.org/x/sys v0.31.0 // indirect
-	golang.org/x/text v0.23.0 // indirect
 	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	cfclient "github.com/cloudfoundry/go-cfclient/v3/client"
 	cfconfig "github.com/cloudfoundry/go-cfclient/v3/config"
@@ -19,22 +20,43 @@ import (
 )
 
 type Config struct {
-	UAAAddress           string `envconfig:"uaa_address" required:"true"`
-	UAAClientID          string `envconfig:"uaa_client_id" required:"true"`
-	UAAClientSecret      string `envconfig:"uaa_client_secret" required:"true"`
-	UAAZone              string `envconfig:"uaa_zone" default:"uaa"`
-	CFAddress            string `envconfig:"cf_address" required:"true"`
-	BrokerUsername       string `envconfig:"broker_username" required:"true"`
-	BrokerPassword       string `envconfig:"broker_password" required:"true"`
-	PasswordLength       int    `envconfig:"password_length" default:"32"`
-	EmailAddress         string `envconfig:"email_address" required:"true"`
-	AccessTokenValidity  int    `envconfig:"access_token_validity" default:"600"`
-	RefreshTokenValidity int    `envconfig:"refresh_token_validity" default:"86400"`
-	Port                 string `envconfig:"port" default:"3000"`
+	UAAAddress            string        `envconfig:"uaa_address" required:"true"`
+	UAAClientID           string        `envconfig:"uaa_client_id" required:"true"`
+	UAAClientSecret       string        `envconfig:"uaa_client_secret" required:"true"`
+	UAAZone               string        `envconfig:"uaa_zone" default:"uaa"`
+	UAAZoneSubdomain      string        `envconfig:"uaa_zone_subdomain"`
+	CFAddress             string        `envconfig:"cf_address" required:"true"`
+	BrokerUsername        string        `envconfig:"broker_username" required:"true"`
+	BrokerPassword        string        `envconfig:"broker_password" required:"true"`
+	PasswordLength        int           `envconfig:"password_length" default:"32"`
+	EmailAddress          string        `envconfig:"email_address" required:"true"`
+	AccessTokenValidity   int           `envconfig:"access_token_validity" default:"600"`
+	RefreshTokenValidity  int           `envconfig:"refresh_token_validity" default:"86400"`
+	Port                  string        `envconfig:"port" default:"3000"`
+	UAARequestTimeout     time.Duration `envconfig:"uaa_request_timeout" default:"30s"`
+	UAAMaxRetries         int           `envconfig:"uaa_max_retries" default:"3"`
+	UAARetryBaseDelay     time.Duration `envconfig:"uaa_retry_base_delay" default:"250ms"`
+	UAARetryMaxDelay      time.Duration `envconfig:"uaa_retry_max_delay" default:"10s"`
+	AsyncOperationTimeout time.Duration `envconfig:"async_operation_timeout" default:"10m"`
+	OrgDenylist           OrgRules      `envconfig:"service_organization_denylist"`
+	OrgAllowlist          OrgRules      `envconfig:"service_organization_allowlist"`
+	OrgPolicyServices     []string      `envconfig:"service_organization_policy_services" default:"cloud-gov-service-account"`
+	CheckRedirectRoutes   bool          `envconfig:"check_redirect_routes" default:"true"`
+	WildcardRedirectOrgs  OrgRules      `envconfig:"wildcard_redirect_allowlist"`
+	RedirectURITemplate   string        `envconfig:"redirect_uri_template" default:"https://{route}/auth/callback"`
+	VerifyOIDCDiscovery   bool          `envconfig:"verify_oidc_discovery"`
+	OptInPlans            []string      `envconfig:"opt_in_plans"`
+	MaxBindingLifetime    time.Duration `envconfig:"max_binding_lifetime" default:"2160h"`
+	ReapInterval          time.Duration `envconfig:"reap_interval" default:"5m"`
+}
+
+func (c Config) OrgPolicy() OrgPolicy {
+	return OrgPolicy{Denylist: c.OrgDenylist, Allowlist: c.OrgAllowlist, Services: c.OrgPolicyServices}
 }
 
 func NewClient(config Config) *http.Client {
-	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, http.DefaultClient)
+	httpClient := &http.Client{Transport: NewRetryTransport(config)}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
 	cfg := &clientcredentials.Config{
 		ClientID:     config.UAAClientID,
 		ClientSecret: config.UAAClientSecret,
@@ -53,6 +75,22 @@ func main() {
 		log.Fatalf("%s", err)
 	}
 
+	plans, err := LoadPlanRegistry("config.json", config.OptInPlans)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	oidc, err := NewOIDCEndpoints(config.UAAAddress, config.UAAZone, config.UAAZoneSubdomain)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	if config.VerifyOIDCDiscovery {
+		discoveryClient := &http.Client{Timeout: config.UAARequestTimeout}
+		if err := oidc.Verify(context.Background(), discoveryClient); err != nil {
+			log.Fatalf("%s", err)
+		}
+	}
+
 	client := NewClient(config)
 
 	cfConfig, _ := cfconfig.New(config.CFAddress, cfconfig.ClientCredentials(config.UAAClientID, config.UAAClientSecret))
@@ -75,13 +113,18 @@ func main() {
 		cfClient:         paasClient,
 		generatePassword: GenerateSecurePassword,
 		config:           config,
+		operations:       NewOperationStore(),
+		plans:            plans,
+		oidc:             oidc,
 	}
+	go broker.RunReaper(context.Background(), config.ReapInterval)
+
 	credentials := brokerapi.BrokerCredentials{
 		Username: config.BrokerUsername,
 		Password: config.BrokerPassword,
 	}
 
-	brokerAPI := brokerapi.New(&broker, logger, credentials)
+	brokerAPI := NewAPI(&broker, logger, credentials)
 	http.Handle("/", brokerAPI)
 	http.ListenAndServe(fmt.Sprintf(":%s", config.Port), nil)
 }
@@ -1,6 +1,8 @@
 package mocks
 
 import (
+	"context"
+
 	cf "github.com/cloudfoundry/go-cfclient/v3/resource"
 	mock "github.com/stretchr/testify/mock"
 )
@@ -10,20 +12,125 @@ type PAASClient struct {
 	mock.Mock
 }
 
-// AssociateOrgAuditorByUsername provides a mock function with given fields: orgID, userName
-func (_m *PAASClient) AssociateOrgAuditorByUsername(orgID string, userName string) (*cf.Role, error) {
-	ret := _m.Called(orgID, userName)
+// AssociateOrgAuditorByUsername provides a mock function with given fields: ctx, orgID, userName
+func (_m *PAASClient) AssociateOrgAuditorByUsername(ctx context.Context, orgID string, userName string) (*cf.Role, error) {
+	ret := _m.Called(ctx, orgID, userName)
+
+	var r0 *cf.Role
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *cf.Role); ok {
+		r0 = rf(ctx, orgID, userName)
+	} else {
+		r0 = ret.Get(0).(*cf.Role)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, orgID, userName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AssociateOrgManagerByUsername provides a mock function with given fields: ctx, orgID, userName
+func (_m *PAASClient) AssociateOrgManagerByUsername(ctx context.Context, orgID string, userName string) (*cf.Role, error) {
+	ret := _m.Called(ctx, orgID, userName)
+
+	var r0 *cf.Role
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *cf.Role); ok {
+		r0 = rf(ctx, orgID, userName)
+	} else {
+		r0 = ret.Get(0).(*cf.Role)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, orgID, userName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AssociateOrgRoleByUserGuid provides a mock function with given fields: ctx, orgID, userGUID, roleType
+func (_m *PAASClient) AssociateOrgRoleByUserGuid(ctx context.Context, orgID string, userGUID string, roleType cf.OrganizationRoleType) (*cf.Role, error) {
+	ret := _m.Called(ctx, orgID, userGUID, roleType)
+
+	var r0 *cf.Role
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, cf.OrganizationRoleType) *cf.Role); ok {
+		r0 = rf(ctx, orgID, userGUID, roleType)
+	} else {
+		r0 = ret.Get(0).(*cf.Role)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, cf.OrganizationRoleType) error); ok {
+		r1 = rf(ctx, orgID, userGUID, roleType)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AssociateOrgUserByUsername provides a mock function with given fields: ctx, orgID, userName
+func (_m *PAASClient) AssociateOrgUserByUsername(ctx context.Context, orgID string, userName string) (*cf.Role, error) {
+	ret := _m.Called(ctx, orgID, userName)
+
+	var r0 *cf.Role
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *cf.Role); ok {
+		r0 = rf(ctx, orgID, userName)
+	} else {
+		r0 = ret.Get(0).(*cf.Role)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, orgID, userName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AssociateSpaceAuditorByUsername provides a mock function with given fields: ctx, spaceID, userName
+func (_m *PAASClient) AssociateSpaceAuditorByUsername(ctx context.Context, spaceID string, userName string) (*cf.Role, error) {
+	ret := _m.Called(ctx, spaceID, userName)
+
+	var r0 *cf.Role
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *cf.Role); ok {
+		r0 = rf(ctx, spaceID, userName)
+	} else {
+		r0 = ret.Get(0).(*cf.Role)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, spaceID, userName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AssociateSpaceDeveloperByUsername provides a mock function with given fields: ctx, spaceID, userName
+func (_m *PAASClient) AssociateSpaceDeveloperByUsername(ctx context.Context, spaceID string, userName string) (*cf.Role, error) {
+	ret := _m.Called(ctx, spaceID, userName)
 
 	var r0 *cf.Role
-	if rf, ok := ret.Get(0).(func(string, string) *cf.Role); ok {
-		r0 = rf(orgID, userName)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *cf.Role); ok {
+		r0 = rf(ctx, spaceID, userName)
 	} else {
 		r0 = ret.Get(0).(*cf.Role)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, string) error); ok {
-		r1 = rf(orgID, userName)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, spaceID, userName)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -31,20 +138,20 @@ func (_m *PAASClient) AssociateOrgAuditorByUsername(orgID string, userName strin
 	return r0, r1
 }
 
-// AssociateOrgUserByUsername provides a mock function with given fields: orgID, userName
-func (_m *PAASClient) AssociateOrgUserByUsername(orgID string, userName string) (*cf.Role, error) {
-	ret := _m.Called(orgID, userName)
+// AssociateSpaceManagerByUsername provides a mock function with given fields: ctx, spaceID, userName
+func (_m *PAASClient) AssociateSpaceManagerByUsername(ctx context.Context, spaceID string, userName string) (*cf.Role, error) {
+	ret := _m.Called(ctx, spaceID, userName)
 
 	var r0 *cf.Role
-	if rf, ok := ret.Get(0).(func(string, string) *cf.Role); ok {
-		r0 = rf(orgID, userName)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *cf.Role); ok {
+		r0 = rf(ctx, spaceID, userName)
 	} else {
 		r0 = ret.Get(0).(*cf.Role)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, string) error); ok {
-		r1 = rf(orgID, userName)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, spaceID, userName)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -52,20 +159,20 @@ func (_m *PAASClient) AssociateOrgUserByUsername(orgID string, userName string)
 	return r0, r1
 }
 
-// AssociateSpaceAuditorByUsername provides a mock function with given fields: spaceID, userName
-func (_m *PAASClient) AssociateSpaceAuditorByUsername(spaceID string, userName string) (*cf.Role, error) {
-	ret := _m.Called(spaceID, userName)
+// AssociateSpaceRoleByUserGuid provides a mock function with given fields: ctx, spaceID, userGUID, roleType
+func (_m *PAASClient) AssociateSpaceRoleByUserGuid(ctx context.Context, spaceID string, userGUID string, roleType cf.SpaceRoleType) (*cf.Role, error) {
+	ret := _m.Called(ctx, spaceID, userGUID, roleType)
 
 	var r0 *cf.Role
-	if rf, ok := ret.Get(0).(func(string, string) *cf.Role); ok {
-		r0 = rf(spaceID, userName)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, cf.SpaceRoleType) *cf.Role); ok {
+		r0 = rf(ctx, spaceID, userGUID, roleType)
 	} else {
 		r0 = ret.Get(0).(*cf.Role)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, string) error); ok {
-		r1 = rf(spaceID, userName)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, cf.SpaceRoleType) error); ok {
+		r1 = rf(ctx, spaceID, userGUID, roleType)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -73,20 +180,20 @@ func (_m *PAASClient) AssociateSpaceAuditorByUsername(spaceID string, userName s
 	return r0, r1
 }
 
-// AssociateSpaceDeveloperByUsername provides a mock function with given fields: spaceID, userName
-func (_m *PAASClient) AssociateSpaceDeveloperByUsername(spaceID string, userName string) (*cf.Role, error) {
-	ret := _m.Called(spaceID, userName)
+// AssociateSpaceSupporterByUsername provides a mock function with given fields: ctx, spaceID, userName
+func (_m *PAASClient) AssociateSpaceSupporterByUsername(ctx context.Context, spaceID string, userName string) (*cf.Role, error) {
+	ret := _m.Called(ctx, spaceID, userName)
 
 	var r0 *cf.Role
-	if rf, ok := ret.Get(0).(func(string, string) *cf.Role); ok {
-		r0 = rf(spaceID, userName)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *cf.Role); ok {
+		r0 = rf(ctx, spaceID, userName)
 	} else {
 		r0 = ret.Get(0).(*cf.Role)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, string) error); ok {
-		r1 = rf(spaceID, userName)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, spaceID, userName)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -94,20 +201,20 @@ func (_m *PAASClient) AssociateSpaceDeveloperByUsername(spaceID string, userName
 	return r0, r1
 }
 
-// CreateUser provides a mock function with given fields: req
-func (_m *PAASClient) CreateUser(guid string) (*cf.User, error) {
-	ret := _m.Called(guid)
+// CreateUser provides a mock function with given fields: ctx, guid
+func (_m *PAASClient) CreateUser(ctx context.Context, guid string) (*cf.User, error) {
+	ret := _m.Called(ctx, guid)
 
 	var r0 *cf.User
-	if rf, ok := ret.Get(0).(func(string) *cf.User); ok {
-		r0 = rf(guid)
+	if rf, ok := ret.Get(0).(func(context.Context, string) *cf.User); ok {
+		r0 = rf(ctx, guid)
 	} else {
 		r0 = ret.Get(0).(*cf.User)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(guid)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, guid)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -115,13 +222,27 @@ func (_m *PAASClient) CreateUser(guid string) (*cf.User, error) {
 	return r0, r1
 }
 
-// DeleteUser provides a mock function with given fields: userID
-func (_m *PAASClient) DeleteUser(userID string) error {
-	ret := _m.Called(userID)
+// DeleteRole provides a mock function with given fields: ctx, guid
+func (_m *PAASClient) DeleteRole(ctx context.Context, guid string) error {
+	ret := _m.Called(ctx, guid)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, guid)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteUser provides a mock function with given fields: ctx, userID
+func (_m *PAASClient) DeleteUser(ctx context.Context, userID string) error {
+	ret := _m.Called(ctx, userID)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string) error); ok {
-		r0 = rf(userID)
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, userID)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -129,20 +250,188 @@ func (_m *PAASClient) DeleteUser(userID string) error {
 	return r0
 }
 
-// GetSpaceByGuid provides a mock function with given fields: guid
-func (_m *PAASClient) GetSpaceByGuid(guid string) (*cf.Space, error) {
-	ret := _m.Called(guid)
+// GetOrganizationByGuid provides a mock function with given fields: ctx, guid
+func (_m *PAASClient) GetOrganizationByGuid(ctx context.Context, guid string) (*cf.Organization, error) {
+	ret := _m.Called(ctx, guid)
+
+	var r0 *cf.Organization
+	if rf, ok := ret.Get(0).(func(context.Context, string) *cf.Organization); ok {
+		r0 = rf(ctx, guid)
+	} else {
+		r0 = ret.Get(0).(*cf.Organization)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, guid)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetSpaceByGuid provides a mock function with given fields: ctx, guid
+func (_m *PAASClient) GetSpaceByGuid(ctx context.Context, guid string) (*cf.Space, error) {
+	ret := _m.Called(ctx, guid)
 
 	var r0 *cf.Space
-	if rf, ok := ret.Get(0).(func(string) *cf.Space); ok {
-		r0 = rf(guid)
+	if rf, ok := ret.Get(0).(func(context.Context, string) *cf.Space); ok {
+		r0 = rf(ctx, guid)
 	} else {
 		r0 = ret.Get(0).(*cf.Space)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(guid)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, guid)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListOrgRolesByUser provides a mock function with given fields: ctx, orgID, userGUID
+func (_m *PAASClient) ListOrgRolesByUser(ctx context.Context, orgID string, userGUID string) ([]*cf.Role, error) {
+	ret := _m.Called(ctx, orgID, userGUID)
+
+	var r0 []*cf.Role
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) []*cf.Role); ok {
+		r0 = rf(ctx, orgID, userGUID)
+	} else {
+		r0 = ret.Get(0).([]*cf.Role)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, orgID, userGUID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListRolesByUser provides a mock function with given fields: ctx, userGUID
+func (_m *PAASClient) ListRolesByUser(ctx context.Context, userGUID string) ([]*cf.Role, error) {
+	ret := _m.Called(ctx, userGUID)
+
+	var r0 []*cf.Role
+	if rf, ok := ret.Get(0).(func(context.Context, string) []*cf.Role); ok {
+		r0 = rf(ctx, userGUID)
+	} else {
+		r0 = ret.Get(0).([]*cf.Role)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, userGUID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListRoutesByApp provides a mock function with given fields: ctx, appGUID
+func (_m *PAASClient) ListRoutesByApp(ctx context.Context, appGUID string) ([]*cf.Route, error) {
+	ret := _m.Called(ctx, appGUID)
+
+	var r0 []*cf.Route
+	if rf, ok := ret.Get(0).(func(context.Context, string) []*cf.Route); ok {
+		r0 = rf(ctx, appGUID)
+	} else {
+		r0 = ret.Get(0).([]*cf.Route)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, appGUID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListRoutesBySpace provides a mock function with given fields: ctx, spaceID
+func (_m *PAASClient) ListRoutesBySpace(ctx context.Context, spaceID string) ([]*cf.Route, error) {
+	ret := _m.Called(ctx, spaceID)
+
+	var r0 []*cf.Route
+	if rf, ok := ret.Get(0).(func(context.Context, string) []*cf.Route); ok {
+		r0 = rf(ctx, spaceID)
+	} else {
+		r0 = ret.Get(0).([]*cf.Route)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, spaceID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListSpaceRolesByUser provides a mock function with given fields: ctx, spaceID, userGUID
+func (_m *PAASClient) ListSpaceRolesByUser(ctx context.Context, spaceID string, userGUID string) ([]*cf.Role, error) {
+	ret := _m.Called(ctx, spaceID, userGUID)
+
+	var r0 []*cf.Role
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) []*cf.Role); ok {
+		r0 = rf(ctx, spaceID, userGUID)
+	} else {
+		r0 = ret.Get(0).([]*cf.Role)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, spaceID, userGUID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListSpacesByName provides a mock function with given fields: ctx, orgID, names
+func (_m *PAASClient) ListSpacesByName(ctx context.Context, orgID string, names []string) ([]*cf.Space, error) {
+	ret := _m.Called(ctx, orgID, names)
+
+	var r0 []*cf.Space
+	if rf, ok := ret.Get(0).(func(context.Context, string, []string) []*cf.Space); ok {
+		r0 = rf(ctx, orgID, names)
+	} else {
+		r0 = ret.Get(0).([]*cf.Space)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, []string) error); ok {
+		r1 = rf(ctx, orgID, names)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ServiceCredentialBindingsByInstanceGuid provides a mock function with given fields: ctx, guid
+func (_m *PAASClient) ServiceCredentialBindingsByInstanceGuid(ctx context.Context, guid string) ([]*cf.ServiceCredentialBinding, error) {
+	ret := _m.Called(ctx, guid)
+
+	var r0 []*cf.ServiceCredentialBinding
+	if rf, ok := ret.Get(0).(func(context.Context, string) []*cf.ServiceCredentialBinding); ok {
+		r0 = rf(ctx, guid)
+	} else {
+		r0 = ret.Get(0).([]*cf.ServiceCredentialBinding)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, guid)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -150,20 +439,20 @@ func (_m *PAASClient) GetSpaceByGuid(guid string) (*cf.Space, error) {
 	return r0, r1
 }
 
-// ServiceInstanceByGuid provides a mock function with given fields: guid
-func (_m *PAASClient) ServiceInstanceByGuid(guid string) (*cf.ServiceInstance, error) {
-	ret := _m.Called(guid)
+// ServiceInstanceByGuid provides a mock function with given fields: ctx, guid
+func (_m *PAASClient) ServiceInstanceByGuid(ctx context.Context, guid string) (*cf.ServiceInstance, error) {
+	ret := _m.Called(ctx, guid)
 
 	var r0 *cf.ServiceInstance
-	if rf, ok := ret.Get(0).(func(string) *cf.ServiceInstance); ok {
-		r0 = rf(guid)
+	if rf, ok := ret.Get(0).(func(context.Context, string) *cf.ServiceInstance); ok {
+		r0 = rf(ctx, guid)
 	} else {
 		r0 = ret.Get(0).(*cf.ServiceInstance)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(guid)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, guid)
 	} else {
 		r1 = ret.Error(1)
 	}
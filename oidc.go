@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OIDCEndpoints are the UAA URLs that apps need to sign users in with the
+// clients of identity-provider bindings.
+type OIDCEndpoints struct {
+	AuthURL     string
+	TokenURL    string
+	UserinfoURL string
+	JWKSURL     string
+	Issuer      string
+	LogoutURL   string
+}
+
+// NewOIDCEndpoints returns the endpoints of a UAA identity zone. UAA serves
+// zones other than the default "uaa" zone from the zone's subdomain of its
+// address, which is chosen when the zone is created and need not match the
+// zone's ID.
+func NewOIDCEndpoints(address, zone, subdomain string) (OIDCEndpoints, error) {
+	u, err := url.Parse(address)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return OIDCEndpoints{}, fmt.Errorf("invalid UAA address %q", address)
+	}
+	if zone != "" && zone != "uaa" {
+		if subdomain == "" {
+			return OIDCEndpoints{}, fmt.Errorf("UAA zone %s needs its subdomain", zone)
+		}
+		u.Host = subdomain + "." + u.Host
+	}
+	base := strings.TrimSuffix(u.String(), "/")
+
+	return OIDCEndpoints{
+		AuthURL:     base + "/oauth/authorize",
+		TokenURL:    base + "/oauth/token",
+		UserinfoURL: base + "/userinfo",
+		JWKSURL:     base + "/token_keys",
+		Issuer:      base + "/oauth/token",
+		LogoutURL:   base + "/logout.do",
+	}, nil
+}
+
+// credentials returns the endpoints as binding credentials.
+func (e OIDCEndpoints) credentials() map[string]string {
+	return map[string]string{
+		"auth_url":     e.AuthURL,
+		"token_url":    e.TokenURL,
+		"userinfo_url": e.UserinfoURL,
+		"jwks_url":     e.JWKSURL,
+		"issuer":       e.Issuer,
+		"logout_url":   e.LogoutURL,
+	}
+}
+
+// openIDConfiguration is the part of UAA's OIDC discovery document that the
+// endpoints are checked against.
+type openIDConfiguration struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// Verify returns an error if UAA's discovery document, fetched from the
+// issuer, disagrees with any of the endpoints. Endpoints that UAA does not
+// publish are not checked.
+func (e OIDCEndpoints) Verify(ctx context.Context, client *http.Client) error {
+	discoveryURL := strings.TrimSuffix(e.Issuer, "/oauth/token") + "/.well-known/openid-configuration"
+	req, _ := http.NewRequestWithContext(ctx, "GET", discoveryURL, nil)
+	req.Header.Add("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		drainBody(resp.Body)
+		return fmt.Errorf("%s returned status %d", discoveryURL, resp.StatusCode)
+	}
+
+	var discovered openIDConfiguration
+	if err := decodeBody(resp.Body, &discovered); err != nil {
+		return err
+	}
+
+	for _, check := range []struct{ name, discovered, expected string }{
+		{"issuer", discovered.Issuer, e.Issuer},
+		{"authorization_endpoint", discovered.AuthorizationEndpoint, e.AuthURL},
+		{"token_endpoint", discovered.TokenEndpoint, e.TokenURL},
+		{"userinfo_endpoint", discovered.UserinfoEndpoint, e.UserinfoURL},
+		{"jwks_uri", discovered.JWKSURI, e.JWKSURL},
+		{"end_session_endpoint", discovered.EndSessionEndpoint, e.LogoutURL},
+	} {
+		if check.discovered != "" && check.discovered != check.expected {
+			return fmt.Errorf("UAA discovery reports %s %s, expected %s", check.name, check.discovered, check.expected)
+		}
+	}
+	return nil
+}
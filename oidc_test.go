@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("oidc endpoints", func() {
+	It("are served from the UAA address for the default zone", func() {
+		oidc, err := NewOIDCEndpoints("https://uaa.example.gov/", "uaa", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(oidc).To(Equal(OIDCEndpoints{
+			AuthURL:     "https://uaa.example.gov/oauth/authorize",
+			TokenURL:    "https://uaa.example.gov/oauth/token",
+			UserinfoURL: "https://uaa.example.gov/userinfo",
+			JWKSURL:     "https://uaa.example.gov/token_keys",
+			Issuer:      "https://uaa.example.gov/oauth/token",
+			LogoutURL:   "https://uaa.example.gov/logout.do",
+		}))
+	})
+
+	It("are served from the zone's subdomain for other zones", func() {
+		oidc, err := NewOIDCEndpoints("https://uaa.example.gov", "7d3c1f0e-zone-id", "login-my-agency")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(oidc.AuthURL).To(Equal("https://login-my-agency.uaa.example.gov/oauth/authorize"))
+		Expect(oidc.Issuer).To(Equal("https://login-my-agency.uaa.example.gov/oauth/token"))
+	})
+
+	It("need the subdomain of other zones", func() {
+		_, err := NewOIDCEndpoints("https://uaa.example.gov", "7d3c1f0e-zone-id", "")
+		Expect(err).To(MatchError("UAA zone 7d3c1f0e-zone-id needs its subdomain"))
+	})
+
+	It("need an absolute UAA address", func() {
+		_, err := NewOIDCEndpoints("uaa.example.gov", "uaa", "")
+		Expect(err).To(MatchError(`invalid UAA address "uaa.example.gov"`))
+	})
+
+	Describe("verification", func() {
+		var (
+			server *httptest.Server
+			oidc   OIDCEndpoints
+			body   string
+		)
+
+		BeforeEach(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.URL.Path).To(Equal("/.well-known/openid-configuration"))
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(strings.ReplaceAll(body, "{server}", server.URL)))
+			}))
+
+			var err error
+			oidc, err = NewOIDCEndpoints(server.URL, "uaa", "")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("accepts a matching discovery document", func() {
+			body = `{
+				"issuer": "{server}/oauth/token",
+				"authorization_endpoint": "{server}/oauth/authorize",
+				"token_endpoint": "{server}/oauth/token",
+				"userinfo_endpoint": "{server}/userinfo",
+				"jwks_uri": "{server}/token_keys"
+			}`
+			Expect(oidc.Verify(context.Background(), server.Client())).To(Succeed())
+		})
+
+		It("refuses a discovery document that disagrees", func() {
+			body = `{"issuer": "https://elsewhere.example.gov/oauth/token"}`
+			err := oidc.Verify(context.Background(), server.Client())
+			Expect(err).To(MatchError("UAA discovery reports issuer https://elsewhere.example.gov/oauth/token, expected " + server.URL + "/oauth/token"))
+		})
+	})
+})
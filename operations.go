@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// Completed operations, and any credentials they produced, are forgotten
+// after operationTTL.
+const operationTTL = time.Hour
+
+type OperationType string
+
+const (
+	BindOperation   OperationType = "bind"
+	UnbindOperation OperationType = "unbind"
+)
+
+type Operation struct {
+	ID          string
+	Type        OperationType
+	InstanceID  string
+	BindingID   string
+	State       brokerapi.LastOperationState
+	Description string
+	Binding     Binding
+	updated     time.Time
+}
+
+// OperationStore tracks asynchronous operations in memory. Operations do not
+// survive a restart of the broker; polling an unknown operation reports it
+// as failed so that the platform can clean up.
+type OperationStore struct {
+	mu         sync.Mutex
+	operations map[string]*Operation
+}
+
+func NewOperationStore() *OperationStore {
+	return &OperationStore{operations: map[string]*Operation{}}
+}
+
+// Start records a new in-progress operation, or returns the operation of the
+// same type already in progress for the binding.
+func (s *OperationStore) Start(opType OperationType, instanceID, bindingID string) (Operation, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune()
+
+	for _, op := range s.operations {
+		if op.Type == opType && op.BindingID == bindingID && op.State == brokerapi.InProgress {
+			return *op, false, nil
+		}
+	}
+
+	b, err := randomBytes(16)
+	if err != nil {
+		return Operation{}, false, err
+	}
+	op := &Operation{
+		ID:         hex.EncodeToString(b),
+		Type:       opType,
+		InstanceID: instanceID,
+		BindingID:  bindingID,
+		State:      brokerapi.InProgress,
+		updated:    time.Now(),
+	}
+	s.operations[op.ID] = op
+
+	return *op, true, nil
+}
+
+// Finish marks an operation as succeeded or, if err is not nil, failed.
+func (s *OperationStore) Finish(id string, binding Binding, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	op, ok := s.operations[id]
+	if !ok {
+		return
+	}
+
+	op.updated = time.Now()
+	if err != nil {
+		op.State = brokerapi.Failed
+		op.Description = err.Error()
+		return
+	}
+	op.State = brokerapi.Succeeded
+	op.Binding = binding
+}
+
+func (s *OperationStore) Get(id string) (Operation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	op, ok := s.operations[id]
+	if !ok {
+		return Operation{}, false
+	}
+	return *op, true
+}
+
+// Binding returns the result of the latest successful bind of bindingID.
+func (s *OperationStore) Binding(bindingID string) (Binding, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var latest *Operation
+	for _, op := range s.operations {
+		if op.Type != BindOperation || op.BindingID != bindingID || op.State != brokerapi.Succeeded {
+			continue
+		}
+		if latest == nil || op.updated.After(latest.updated) {
+			latest = op
+		}
+	}
+	if latest == nil {
+		return Binding{}, false
+	}
+	return latest.Binding, true
+}
+
+// Forget drops every operation recorded for bindingID.
+func (s *OperationStore) Forget(bindingID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, op := range s.operations {
+		if op.BindingID == bindingID && op.State != brokerapi.InProgress {
+			delete(s.operations, id)
+		}
+	}
+}
+
+func (s *OperationStore) prune() {
+	cutoff := time.Now().Add(-operationTTL)
+	for id, op := range s.operations {
+		if op.State != brokerapi.InProgress && op.updated.Before(cutoff) {
+			delete(s.operations, id)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var guidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// OrgRule names an org, by GUID or name, that a policy list applies to.
+// Service and Plan, matched by ID or name, limit the rule to one service
+// offering or one of its plans.
+type OrgRule struct {
+	Service string
+	Plan    string
+	Org     string
+}
+
+// OrgRules is a list of org rules read from the environment as entries of the
+// form "[service[/plan]:]org", separated by commas or whitespace.
+type OrgRules []OrgRule
+
+func (r *OrgRules) Decode(value string) error {
+	rules := OrgRules{}
+	entries := strings.FieldsFunc(value, func(c rune) bool {
+		return c == ',' || unicode.IsSpace(c)
+	})
+	for _, entry := range entries {
+		rule := OrgRule{Org: entry}
+		if i := strings.Index(entry, ":"); i >= 0 {
+			rule.Org = entry[i+1:]
+			rule.Service = entry[:i]
+			if j := strings.Index(rule.Service, "/"); j >= 0 {
+				rule.Plan = rule.Service[j+1:]
+				rule.Service = rule.Service[:j]
+			}
+		}
+		if rule.Org == "" || (rule.Plan == "" && strings.Contains(entry, "/")) {
+			return fmt.Errorf("invalid org rule %q", entry)
+		}
+		rules = append(rules, rule)
+	}
+	*r = rules
+	return nil
+}
+
+// For returns the rules that apply to the given plan.
+func (r OrgRules) For(plan PlanRef) OrgRules {
+	rules := OrgRules{}
+	for _, rule := range r {
+		if rule.Service != "" && rule.Service != plan.ServiceID && rule.Service != plan.ServiceName {
+			continue
+		}
+		if rule.Plan != "" && rule.Plan != plan.PlanID && rule.Plan != plan.PlanName {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// Match reports whether any rule names the org with the given GUID or name.
+func (r OrgRules) Match(orgGUID, orgName string) bool {
+	for _, rule := range r {
+		if rule.Org == orgGUID || (orgName != "" && rule.Org == orgName) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceScoped returns the rules that name a service.
+func (r OrgRules) ServiceScoped() OrgRules {
+	rules := OrgRules{}
+	for _, rule := range r {
+		if rule.Service != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// PlanScoped returns the rules that name a plan.
+func (r OrgRules) PlanScoped() OrgRules {
+	rules := OrgRules{}
+	for _, rule := range r {
+		if rule.Plan != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// NeedsName reports whether any rule names an org by name rather than GUID.
+func (r OrgRules) NeedsName() bool {
+	for _, rule := range r {
+		if !guidPattern.MatchString(rule.Org) {
+			return true
+		}
+	}
+	return false
+}
+
+// PlanRef identifies a plan of a service offering by ID and name. Gated plans
+// may only be used by orgs that the allowlist names for the plan itself.
+type PlanRef struct {
+	ServiceID   string
+	ServiceName string
+	PlanID      string
+	PlanName    string
+	Gated       bool
+}
+
+// OrgPolicy decides which orgs may provision and bind each plan. An org is
+// refused if it is on the denylist, if the allowlist has rules for the plan
+// and the org is not among them, or if the plan is gated and no allowlist rule
+// for the plan names the org. Rules that name no service apply only to the
+// services, by ID or name, in Services, or to every service if it is empty.
+type OrgPolicy struct {
+	Denylist  OrgRules
+	Allowlist OrgRules
+	Services  []string
+}
+
+// Rules returns the deny and allow rules that apply to plan.
+func (p OrgPolicy) Rules(plan PlanRef) (OrgRules, OrgRules) {
+	deny, allow := p.Denylist.For(plan), p.Allowlist.For(plan)
+	if len(p.Services) > 0 && !containsString(p.Services, plan.ServiceID) && !containsString(p.Services, plan.ServiceName) {
+		return deny.ServiceScoped(), allow.ServiceScoped()
+	}
+	return deny, allow
+}
+
+// Applies reports whether any rule applies to plan.
+func (p OrgPolicy) Applies(plan PlanRef) bool {
+	deny, allow := p.Rules(plan)
+	return plan.Gated || len(deny) > 0 || len(allow) > 0
+}
+
+// Check returns an error explaining why the org may not use plan, if it may
+// not. orgName may be empty if no rule names an org by name.
+func (p OrgPolicy) Check(plan PlanRef, orgGUID, orgName string) error {
+	deny, allow := p.Rules(plan)
+
+	var reason string
+	switch {
+	case deny.Match(orgGUID, orgName):
+		reason = "is on the broker's denylist"
+	case len(allow) > 0 && !allow.Match(orgGUID, orgName):
+		reason = "is not on the broker's allowlist"
+	case plan.Gated && !allow.PlanScoped().Match(orgGUID, orgName):
+		reason = "has not been approved by the broker's allowlist"
+	default:
+		return nil
+	}
+
+	org := orgGUID
+	if orgName != "" {
+		org = orgName
+	}
+	return brokerapi.NewFailureResponse(
+		fmt.Errorf("Organization %s %s for plan %s of service %s; contact support to request access", org, reason, plan.PlanName, plan.ServiceName),
+		http.StatusForbidden,
+		"org-not-permitted",
+	)
+}
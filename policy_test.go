@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeOrgRules(t *testing.T) {
+	rules := OrgRules{}
+	err := rules.Decode("org-a, service:org-b\nservice/plan:org-c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := OrgRules{
+		{Org: "org-a"},
+		{Service: "service", Org: "org-b"},
+		{Service: "service", Plan: "plan", Org: "org-c"},
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("expected %v, got %v", expected, rules)
+	}
+
+	for _, value := range []string{"service:", "service/:org"} {
+		if err := rules.Decode(value); err == nil {
+			t.Errorf("expected an error decoding %q", value)
+		}
+	}
+}
+
+func TestOrgPolicyCheck(t *testing.T) {
+	plan := PlanRef{ServiceID: "service-guid", ServiceName: "service", PlanID: "plan-guid", PlanName: "plan"}
+	otherPlan := PlanRef{ServiceID: "service-guid", ServiceName: "service", PlanID: "other-guid", PlanName: "other"}
+
+	policy := OrgPolicy{
+		Denylist:  OrgRules{{Org: "denied-guid"}},
+		Allowlist: OrgRules{{Service: "service", Plan: "plan", Org: "allowed-org"}},
+	}
+
+	cases := []struct {
+		plan    PlanRef
+		orgGUID string
+		orgName string
+		allowed bool
+	}{
+		{plan, "allowed-guid", "allowed-org", true},
+		{plan, "other-guid", "other-org", false},
+		{otherPlan, "other-guid", "other-org", true},
+		{otherPlan, "denied-guid", "", false},
+	}
+	for _, c := range cases {
+		err := policy.Check(c.plan, c.orgGUID, c.orgName)
+		if (err == nil) != c.allowed {
+			t.Errorf("plan %s, org %s: expected allowed %v, got %v", c.plan.PlanName, c.orgGUID, c.allowed, err)
+		}
+	}
+}
+
+func TestOrgPolicyCheckGatedPlan(t *testing.T) {
+	plan := PlanRef{ServiceID: "service-guid", ServiceName: "service", PlanID: "plan-guid", PlanName: "plan", Gated: true}
+
+	cases := []struct {
+		allowlist OrgRules
+		allowed   bool
+	}{
+		{OrgRules{}, false},
+		{OrgRules{{Org: "org-guid"}}, false},
+		{OrgRules{{Service: "service", Org: "org-guid"}}, false},
+		{OrgRules{{Service: "service", Plan: "plan", Org: "org-guid"}}, true},
+	}
+	for _, c := range cases {
+		policy := OrgPolicy{Allowlist: c.allowlist}
+		if !policy.Applies(plan) {
+			t.Errorf("expected the policy to apply to a gated plan")
+		}
+		err := policy.Check(plan, "org-guid", "")
+		if (err == nil) != c.allowed {
+			t.Errorf("allowlist %v: expected allowed %v, got %v", c.allowlist, c.allowed, err)
+		}
+	}
+}
+
+func TestOrgPolicyServices(t *testing.T) {
+	accounts := PlanRef{ServiceID: "accounts-guid", ServiceName: "accounts", PlanID: "plan-guid", PlanName: "plan"}
+	clients := PlanRef{ServiceID: "clients-guid", ServiceName: "clients", PlanID: "plan-guid", PlanName: "plan"}
+
+	policy := OrgPolicy{
+		Denylist: OrgRules{{Org: "denied-guid"}, {Service: "clients", Org: "clients-denied-guid"}},
+		Services: []string{"accounts"},
+	}
+
+	cases := []struct {
+		plan    PlanRef
+		orgGUID string
+		allowed bool
+	}{
+		{accounts, "denied-guid", false},
+		{clients, "denied-guid", true},
+		{clients, "clients-denied-guid", false},
+	}
+	for _, c := range cases {
+		err := policy.Check(c.plan, c.orgGUID, "")
+		if (err == nil) != c.allowed {
+			t.Errorf("service %s, org %s: expected allowed %v, got %v", c.plan.ServiceName, c.orgGUID, c.allowed, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// expiryClientPrefix begins the ID of the UAA client that records when a
+// binding expires, followed by the binding ID.
+const expiryClientPrefix = "binding-expiry-"
+
+// bindingExpiry records when a binding expires and the plan whose cleanup
+// removes its credentials.
+type bindingExpiry struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	ServiceID string    `json:"service_id"`
+	PlanID    string    `json:"plan_id"`
+}
+
+// parseExpiresIn returns the lifetime given in seconds by the expires_in bind
+// parameter, or zero if the binding does not expire.
+func (b *DeployerAccountBroker) parseExpiresIn(details brokerapi.BindDetails) (time.Duration, error) {
+	if len(details.RawParameters) == 0 {
+		return 0, nil
+	}
+
+	var params struct {
+		ExpiresIn int64 `json:"expires_in"`
+	}
+	if err := json.Unmarshal(details.RawParameters, &params); err != nil {
+		return 0, invalidParameters(fmt.Errorf("Invalid parameters: %s", err))
+	}
+	if params.ExpiresIn < 0 {
+		return 0, invalidParameters(errors.New(`Field "expires_in" must be positive`))
+	}
+
+	expiresIn := time.Duration(params.ExpiresIn) * time.Second
+	if expiresIn > b.config.MaxBindingLifetime {
+		return 0, invalidParameters(fmt.Errorf(`Field "expires_in" may be at most %d seconds`, int64(b.config.MaxBindingLifetime/time.Second)))
+	}
+	return expiresIn, nil
+}
+
+// recordExpiry keeps when a binding expires on a UAA client for the reaper
+// to find. Unlike metadata on the binding, space developers cannot change it.
+func (b *DeployerAccountBroker) recordExpiry(ctx context.Context, plan RegisteredPlan, bindingID string, expiresAt time.Time) error {
+	client := b.stateClient(expiryClientPrefix + bindingID)
+	client.BindingExpiry = &bindingExpiry{
+		ExpiresAt: expiresAt,
+		ServiceID: plan.ServiceID,
+		PlanID:    plan.PlanID,
+	}
+
+	_, err := b.uaaClient.CreateClient(ctx, client)
+	if !errors.Is(err, ErrUAAConflict) {
+		return err
+	}
+	// The platform retried a bind, whose new credentials get a new expiry
+	if err := b.deleteClient(ctx, client.ID); err != nil {
+		return err
+	}
+	_, err = b.uaaClient.CreateClient(ctx, client)
+	return err
+}
+
+// RunReaper expires bindings every interval until ctx is done.
+func (b *DeployerAccountBroker) RunReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.Reap(ctx); err != nil {
+				b.logger.Error("reap", err)
+			}
+		}
+	}
+}
+
+// Reap removes the credentials of each binding whose expiry has passed, the
+// same way as unbinding it, and then its expiry. The binding itself remains
+// until it is deleted through the platform. Expiries are kept when bindings
+// are unbound, so the reaper unbinds those again and finds nothing to remove.
+func (b *DeployerAccountBroker) Reap(ctx context.Context) error {
+	clients, err := b.uaaClient.ListClients(ctx, fmt.Sprintf(`client_id sw "%s"`, expiryClientPrefix))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, client := range clients {
+		bindingID := strings.TrimPrefix(client.ID, expiryClientPrefix)
+		logger := b.logger.Session("reap", lager.Data{"bindingID": bindingID})
+
+		expiry := client.BindingExpiry
+		if expiry == nil {
+			logger.Info("no-expiry")
+			continue
+		}
+		if now.Before(expiry.ExpiresAt) {
+			continue
+		}
+		plan, ok := b.plans.Plan(expiry.ServiceID, expiry.PlanID)
+		if !ok {
+			logger.Info("unknown-plan", lager.Data{"serviceID": expiry.ServiceID, "planID": expiry.PlanID})
+			continue
+		}
+
+		if err := b.unbind(ctx, plan, bindingID); err != nil {
+			logger.Error("unbind", err)
+			continue
+		}
+		if err := b.deleteClient(ctx, client.ID); err != nil {
+			logger.Error("delete-expiry", err)
+			continue
+		}
+		logger.Info("expired", lager.Data{"expiresAt": expiry.ExpiresAt})
+	}
+
+	return nil
+}
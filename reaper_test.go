@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	cf "github.com/cloudfoundry/go-cfclient/v3/resource"
+	"github.com/pivotal-cf/brokerapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/cloud-gov/uaa-credentials-broker/mocks"
+)
+
+var _ = Describe("reaper", func() {
+	var (
+		uaaClient FakeUAAClient
+		cfClient  mocks.PAASClient
+		broker    DeployerAccountBroker
+	)
+
+	BeforeEach(func() {
+		uaaClient = FakeUAAClient{userGUID: "user-guid", userName: "binding-guid"}
+		cfClient = mocks.PAASClient{}
+		broker = newTestBroker(&uaaClient, &cfClient)
+		broker.config.MaxBindingLifetime = 24 * time.Hour
+	})
+
+	expiryClient := func(bindingID string, expiresAt time.Time, planID string) Client {
+		return Client{
+			ID:            expiryClientPrefix + bindingID,
+			BindingExpiry: &bindingExpiry{ExpiresAt: expiresAt, ServiceID: clientAccountGUID, PlanID: planID},
+		}
+	}
+	expiryFilter := `client_id sw "binding-expiry-"`
+
+	Describe("bind", func() {
+		bindExpiring := func(params string) (Binding, error) {
+			return broker.BindAsync(
+				context.Background(),
+				"instance-guid",
+				"binding-guid",
+				BindDetails{BindDetails: brokerapi.BindDetails{
+					ServiceID:     clientAccountGUID,
+					PlanID:        oauthClientGUID,
+					RawParameters: []byte(params),
+				}},
+				false,
+			)
+		}
+
+		BeforeEach(func() {
+			cfClient.On("ServiceInstanceByGuid", mock.Anything, "instance-guid").Return(&cf.ServiceInstance{}, nil).Maybe()
+			uaaClient.On("GetClient", "instance-guid").Return(Client{}, &UAAError{StatusCode: 404}).Maybe()
+		})
+
+		It("records the expiry on a UAA client and returns it as binding metadata", func() {
+			var recorded Client
+			uaaClient.On("CreateClient", mock.MatchedBy(func(client Client) bool {
+				return client.ID == "binding-guid"
+			})).Return(Client{}, nil)
+			uaaClient.On("CreateClient", mock.MatchedBy(func(client Client) bool {
+				return client.ID == "binding-expiry-binding-guid"
+			})).Return(Client{}, nil).Run(func(args mock.Arguments) {
+				recorded = args.Get(0).(Client)
+			})
+
+			before := time.Now()
+			binding, err := bindExpiring(`{"redirect_uri": ["https://cloud.gov"], "expires_in": 3600}`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Metadata).NotTo(BeNil())
+			expiresAt, err := time.Parse(time.RFC3339, binding.Metadata.ExpiresAt)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(expiresAt).To(BeTemporally("~", before.Add(time.Hour), 2*time.Second))
+
+			Expect(recorded.Scope).To(Equal([]string{uaaNone}))
+			Expect(recorded.Authorities).To(Equal([]string{uaaNone}))
+			Expect(recorded.BindingExpiry).NotTo(BeNil())
+			Expect(recorded.BindingExpiry.PlanID).To(Equal(oauthClientGUID))
+			Expect(recorded.BindingExpiry.ExpiresAt).To(BeTemporally("~", expiresAt, time.Second))
+		})
+
+		It("replaces the expiry of a retried bind", func() {
+			uaaClient.On("CreateClient", mock.MatchedBy(func(client Client) bool {
+				return client.ID == "binding-guid"
+			})).Return(Client{}, nil)
+			uaaClient.On("CreateClient", mock.MatchedBy(func(client Client) bool {
+				return client.ID == "binding-expiry-binding-guid"
+			})).Return(Client{}, &UAAError{StatusCode: 409}).Once()
+			uaaClient.On("DeleteClient", "binding-expiry-binding-guid").Return(nil)
+			uaaClient.On("CreateClient", mock.MatchedBy(func(client Client) bool {
+				return client.ID == "binding-expiry-binding-guid"
+			})).Return(Client{}, nil).Once()
+
+			_, err := bindExpiring(`{"redirect_uri": ["https://cloud.gov"], "expires_in": 3600}`)
+			Expect(err).NotTo(HaveOccurred())
+			uaaClient.AssertExpectations(GinkgoT())
+		})
+
+		It("does not record an expiry by default", func() {
+			uaaClient.On("CreateClient", mock.Anything).Return(Client{}, nil)
+
+			binding, err := bindExpiring(`{"redirect_uri": ["https://cloud.gov"]}`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Metadata).To(BeNil())
+			uaaClient.AssertNumberOfCalls(GinkgoT(), "CreateClient", 1)
+		})
+
+		It("bounds expires_in by the configured lifetime", func() {
+			_, err := bindExpiring(`{"redirect_uri": ["https://cloud.gov"], "expires_in": 86401}`)
+			Expect(err).To(MatchError(`Field "expires_in" may be at most 86400 seconds`))
+			uaaClient.AssertNotCalled(GinkgoT(), "CreateClient", mock.Anything)
+		})
+
+		It("removes the credentials if the expiry cannot be recorded", func() {
+			uaaClient.On("CreateClient", mock.MatchedBy(func(client Client) bool {
+				return client.ID == "binding-guid"
+			})).Return(Client{}, nil)
+			uaaClient.On("CreateClient", mock.MatchedBy(func(client Client) bool {
+				return client.ID == "binding-expiry-binding-guid"
+			})).Return(Client{}, &UAAError{StatusCode: 503})
+			uaaClient.On("DeleteClient", "binding-guid").Return(nil)
+
+			_, err := bindExpiring(`{"redirect_uri": ["https://cloud.gov"], "expires_in": 3600}`)
+			Expect(errors.Is(err, ErrUAAServerError)).To(BeTrue())
+			uaaClient.AssertExpectations(GinkgoT())
+		})
+	})
+
+	Describe("Reap", func() {
+		It("unbinds expired bindings and removes their expiry", func() {
+			uaaClient.On("ListClients", expiryFilter).Return([]Client{
+				expiryClient("expired-guid", time.Now().Add(-time.Minute), oauthClientGUID),
+				expiryClient("current-guid", time.Now().Add(time.Hour), oauthClientGUID),
+			}, nil)
+			uaaClient.On("DeleteClient", "expired-guid").Return(nil)
+			uaaClient.On("DeleteClient", "binding-expiry-expired-guid").Return(nil)
+
+			Expect(broker.Reap(context.Background())).To(Succeed())
+			uaaClient.AssertExpectations(GinkgoT())
+			uaaClient.AssertNotCalled(GinkgoT(), "DeleteClient", "current-guid")
+			uaaClient.AssertNotCalled(GinkgoT(), "DeleteClient", "binding-expiry-current-guid")
+		})
+
+		It("keeps the expiry of bindings it failed to unbind", func() {
+			uaaClient.On("ListClients", expiryFilter).Return([]Client{
+				expiryClient("expired-guid", time.Now().Add(-time.Minute), oauthClientGUID),
+			}, nil)
+			uaaClient.On("DeleteClient", "expired-guid").Return(&UAAError{StatusCode: 500})
+
+			Expect(broker.Reap(context.Background())).To(Succeed())
+			uaaClient.AssertNotCalled(GinkgoT(), "DeleteClient", "binding-expiry-expired-guid")
+		})
+
+		It("removes the expiry of bindings already unbound", func() {
+			uaaClient.On("ListClients", expiryFilter).Return([]Client{
+				expiryClient("unbound-guid", time.Now().Add(-time.Minute), oauthClientGUID),
+			}, nil)
+			uaaClient.On("DeleteClient", "unbound-guid").Return(&UAAError{StatusCode: 404})
+			uaaClient.On("DeleteClient", "binding-expiry-unbound-guid").Return(nil)
+
+			Expect(broker.Reap(context.Background())).To(Succeed())
+			uaaClient.AssertExpectations(GinkgoT())
+		})
+
+		It("skips bindings of plans no longer in the catalog", func() {
+			uaaClient.On("ListClients", expiryFilter).Return([]Client{
+				expiryClient("expired-guid", time.Now().Add(-time.Minute), "retired-plan-guid"),
+			}, nil)
+
+			Expect(broker.Reap(context.Background())).To(Succeed())
+			uaaClient.AssertNotCalled(GinkgoT(), "DeleteClient", mock.Anything)
+		})
+	})
+})
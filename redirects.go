@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// checkRedirectURIs returns an OSB error unless each of uris uses https, has
+// no wildcards unless the target's org may use them, and, if the broker checks
+// routes and checkRoutes is set, has the host of a route in the target's
+// space. UAA matches redirects against wildcard patterns, so a wildcard could
+// send users' codes to any host that fits it.
+func (b *DeployerAccountBroker) checkRedirectURIs(ctx context.Context, plan RegisteredPlan, uris []string, target *clientTarget, checkRoutes bool) error {
+	if len(uris) == 0 {
+		return nil
+	}
+
+	var hosts map[string]bool
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return invalidParameters(fmt.Errorf("Redirect URI %s must be an https URL", uri))
+		}
+
+		if strings.Contains(uri, "*") {
+			allowed, err := b.wildcardRedirectsAllowed(ctx, plan, target)
+			if err != nil {
+				return err
+			}
+			if !allowed {
+				return invalidParameters(fmt.Errorf("Redirect URI %s may not contain wildcards", uri))
+			}
+		}
+
+		if !b.config.CheckRedirectRoutes || !checkRoutes {
+			continue
+		}
+		if hosts == nil {
+			if hosts, err = b.routeHosts(ctx, target.SpaceID); err != nil {
+				return err
+			}
+		}
+		if !hosts[strings.ToLower(u.Hostname())] {
+			return invalidParameters(fmt.Errorf("Redirect URI %s does not match a route in the service instance's space", uri))
+		}
+	}
+	return nil
+}
+
+// appRedirectURIs returns a redirect URI for each HTTP route of an app, made
+// from the configured template by replacing "{route}" with the route's URL.
+func (b *DeployerAccountBroker) appRedirectURIs(ctx context.Context, appGUID string) ([]string, error) {
+	routes, err := b.cfClient.ListRoutesByApp(ctx, appGUID)
+	if err != nil {
+		return nil, err
+	}
+
+	uris := []string{}
+	for _, route := range routes {
+		if route.Protocol == "tcp" {
+			continue
+		}
+		uri := strings.ReplaceAll(b.config.RedirectURITemplate, "{route}", route.URL)
+		if !containsString(uris, uri) {
+			uris = append(uris, uri)
+		}
+	}
+	if len(uris) == 0 {
+		return nil, invalidParameters(fmt.Errorf(`App %s has no routes to derive redirect URIs from; pass field "redirect_uri"`, appGUID))
+	}
+	return uris, nil
+}
+
+// wildcardRedirectsAllowed reports whether the wildcard redirect allowlist
+// names the target's org for plan.
+func (b *DeployerAccountBroker) wildcardRedirectsAllowed(ctx context.Context, plan RegisteredPlan, target *clientTarget) (bool, error) {
+	rules := b.config.WildcardRedirectOrgs.For(plan.PlanRef)
+	if len(rules) == 0 {
+		return false, nil
+	}
+
+	orgName := target.OrgName
+	if rules.NeedsName() {
+		var err error
+		if orgName, err = b.orgName(ctx, target); err != nil {
+			return false, err
+		}
+	}
+	return rules.Match(target.OrgID, orgName), nil
+}
+
+// routeHosts returns the lower-cased hosts of the routes in a space.
+func (b *DeployerAccountBroker) routeHosts(ctx context.Context, spaceID string) (map[string]bool, error) {
+	routes, err := b.cfClient.ListRoutesBySpace(ctx, spaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := map[string]bool{}
+	for _, route := range routes {
+		host, _, _ := strings.Cut(route.URL, "/")
+		host, _, _ = strings.Cut(host, ":")
+		hosts[strings.ToLower(host)] = true
+	}
+	return hosts, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+
+	cf "github.com/cloudfoundry/go-cfclient/v3/resource"
+	"github.com/pivotal-cf/brokerapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/cloud-gov/uaa-credentials-broker/mocks"
+)
+
+var _ = Describe("redirect URIs", func() {
+	var (
+		uaaClient FakeUAAClient
+		cfClient  mocks.PAASClient
+		broker    DeployerAccountBroker
+	)
+
+	BeforeEach(func() {
+		uaaClient = FakeUAAClient{}
+		cfClient = mocks.PAASClient{}
+		broker = newTestBroker(&uaaClient, &cfClient)
+		broker.config.CheckRedirectRoutes = true
+		broker.config.RedirectURITemplate = "https://{route}/auth/callback"
+
+		cfClient.On("ServiceInstanceByGuid", mock.Anything, "instance-guid").Return(&cf.ServiceInstance{}, nil).Maybe()
+		uaaClient.On("GetClient", "instance-guid").Return(Client{}, &UAAError{StatusCode: 404}).Maybe()
+		cfClient.On("ListRoutesBySpace", mock.Anything, "space-guid").Return([]*cf.Route{
+			{URL: "my-app.app.cloud.gov"},
+			{URL: "login.example.gov/callback"},
+		}, nil).Maybe()
+		uaaClient.On("CreateClient", mock.Anything).Return(Client{}, nil).Maybe()
+	})
+
+	bindRedirects := func(params string) error {
+		_, err := broker.BindAsync(
+			context.Background(),
+			"instance-guid",
+			"binding-guid",
+			BindDetails{
+				BindDetails: brokerapi.BindDetails{
+					ServiceID:     clientAccountGUID,
+					PlanID:        oauthClientGUID,
+					RawParameters: []byte(params),
+				},
+				RawContext: []byte(`{"organization_guid":"org-guid","space_guid":"space-guid","organization_name":"my-org"}`),
+			},
+			false,
+		)
+		return err
+	}
+
+	It("accepts hosts of routes in the instance's space", func() {
+		Expect(bindRedirects(`{"redirect_uri": ["https://My-App.app.cloud.gov/auth", "https://login.example.gov/callback"]}`)).To(Succeed())
+		uaaClient.AssertCalled(GinkgoT(), "CreateClient", mock.Anything)
+	})
+
+	It("requires https", func() {
+		err := bindRedirects(`{"redirect_uri": ["http://my-app.app.cloud.gov/auth"]}`)
+		Expect(err).To(MatchError("Redirect URI http://my-app.app.cloud.gov/auth must be an https URL"))
+		uaaClient.AssertNotCalled(GinkgoT(), "CreateClient", mock.Anything)
+	})
+
+	It("refuses hosts without a route in the space", func() {
+		err := bindRedirects(`{"redirect_uri": ["https://my-app.app.cloud.gov/auth", "https://evil.example.com/auth"]}`)
+		Expect(err).To(MatchError("Redirect URI https://evil.example.com/auth does not match a route in the service instance's space"))
+		uaaClient.AssertNotCalled(GinkgoT(), "CreateClient", mock.Anything)
+	})
+
+	It("accepts any https host when routes are not checked", func() {
+		broker.config.CheckRedirectRoutes = false
+
+		Expect(bindRedirects(`{"redirect_uri": ["https://elsewhere.example.com/auth"]}`)).To(Succeed())
+		cfClient.AssertNotCalled(GinkgoT(), "ListRoutesBySpace", mock.Anything, mock.Anything)
+	})
+
+	Describe("wildcards", func() {
+		It("are refused by default", func() {
+			err := bindRedirects(`{"redirect_uri": ["https://my-app.app.cloud.gov/**"]}`)
+			Expect(err).To(MatchError("Redirect URI https://my-app.app.cloud.gov/** may not contain wildcards"))
+		})
+
+		It("are accepted for orgs on the allowlist", func() {
+			Expect(broker.config.WildcardRedirectOrgs.Decode("cloud-gov-identity-provider:my-org")).To(Succeed())
+
+			Expect(bindRedirects(`{"redirect_uri": ["https://my-app.app.cloud.gov/**"]}`)).To(Succeed())
+		})
+
+		It("are refused for other orgs", func() {
+			Expect(broker.config.WildcardRedirectOrgs.Decode("other-org")).To(Succeed())
+
+			err := bindRedirects(`{"redirect_uri": ["https://my-app.app.cloud.gov/**"]}`)
+			Expect(err).To(MatchError("Redirect URI https://my-app.app.cloud.gov/** may not contain wildcards"))
+		})
+	})
+
+	Describe("app bindings", func() {
+		bindApp := func(params string) error {
+			_, err := broker.BindAsync(
+				context.Background(),
+				"instance-guid",
+				"binding-guid",
+				BindDetails{
+					BindDetails: brokerapi.BindDetails{
+						AppGUID:       "app-guid",
+						ServiceID:     clientAccountGUID,
+						PlanID:        oauthClientGUID,
+						RawParameters: []byte(params),
+					},
+					RawContext: []byte(`{"organization_guid":"org-guid","space_guid":"space-guid"}`),
+				},
+				false,
+			)
+			return err
+		}
+
+		It("derive redirect URIs from the app's routes", func() {
+			port := 1024
+			cfClient.On("ListRoutesByApp", mock.Anything, "app-guid").Return([]*cf.Route{
+				{URL: "my-app.app.cloud.gov", Protocol: "http"},
+				{URL: "tcp.app.cloud.gov:1024", Protocol: "tcp", Port: &port},
+			}, nil)
+
+			Expect(bindApp(``)).To(Succeed())
+			uaaClient.AssertCalled(GinkgoT(), "CreateClient", mock.MatchedBy(func(client Client) bool {
+				return sameStrings(client.RedirectURI, []string{"https://my-app.app.cloud.gov/auth/callback"})
+			}))
+		})
+
+		It("do not check derived redirect URIs against the instance's space", func() {
+			cfClient.On("ListRoutesByApp", mock.Anything, "app-guid").Return([]*cf.Route{
+				{URL: "shared-app.other.gov", Protocol: "http"},
+			}, nil)
+
+			Expect(bindApp(``)).To(Succeed())
+			uaaClient.AssertCalled(GinkgoT(), "CreateClient", mock.MatchedBy(func(client Client) bool {
+				return sameStrings(client.RedirectURI, []string{"https://shared-app.other.gov/auth/callback"})
+			}))
+			cfClient.AssertNotCalled(GinkgoT(), "ListRoutesBySpace", mock.Anything, mock.Anything)
+		})
+
+		It("still refuse wildcards derived from the app's routes", func() {
+			cfClient.On("ListRoutesByApp", mock.Anything, "app-guid").Return([]*cf.Route{
+				{URL: "*.app.cloud.gov", Protocol: "http"},
+			}, nil)
+
+			err := bindApp(``)
+			Expect(err).To(MatchError("Redirect URI https://*.app.cloud.gov/auth/callback may not contain wildcards"))
+			uaaClient.AssertNotCalled(GinkgoT(), "CreateClient", mock.Anything)
+		})
+
+		It("use the redirect URIs given instead", func() {
+			Expect(bindApp(`{"redirect_uri": ["https://login.example.gov/callback"]}`)).To(Succeed())
+			cfClient.AssertNotCalled(GinkgoT(), "ListRoutesByApp", mock.Anything, mock.Anything)
+		})
+
+		It("need routes when no redirect URIs are given", func() {
+			cfClient.On("ListRoutesByApp", mock.Anything, "app-guid").Return([]*cf.Route{}, nil)
+
+			err := bindApp(``)
+			Expect(err).To(MatchError(`App app-guid has no routes to derive redirect URIs from; pass field "redirect_uri"`))
+		})
+
+		It("derive nothing for client credentials clients", func() {
+			Expect(bindApp(`{"grant_types": ["client_credentials"]}`)).To(Succeed())
+			cfClient.AssertNotCalled(GinkgoT(), "ListRoutesByApp", mock.Anything, mock.Anything)
+		})
+	})
+})
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+)
+
+type sagaStep struct {
+	name string
+	undo func(ctx context.Context) error
+}
+
+// saga records the completed steps of a multi-step operation so that they can
+// be undone in reverse order when a later step fails.
+type saga struct {
+	logger  lager.Logger
+	context func() (context.Context, context.CancelFunc)
+	steps   []sagaStep
+}
+
+// newSaga returns a saga whose steps are undone on a context from undoContext.
+// Operations usually fail because their request was cancelled, so that context
+// should not be the request's.
+func newSaga(logger lager.Logger, undoContext func() (context.Context, context.CancelFunc)) *saga {
+	return &saga{logger: logger, context: undoContext}
+}
+
+// Completed records a step along with the function that undoes it.
+func (s *saga) Completed(name string, undo func(ctx context.Context) error) {
+	s.steps = append(s.steps, sagaStep{name: name, undo: undo})
+}
+
+// Rollback undoes every completed step, most recent first, and returns err
+// annotated with any steps that could not be undone.
+func (s *saga) Rollback(err error) error {
+	ctx, cancel := s.context()
+	defer cancel()
+
+	failed := []string{}
+	for i := len(s.steps) - 1; i >= 0; i-- {
+		step := s.steps[i]
+		s.logger.Info("rollback", lager.Data{"step": step.name})
+		if undoErr := step.undo(ctx); undoErr != nil {
+			s.logger.Error("rollback-failed", undoErr, lager.Data{"step": step.name})
+			failed = append(failed, step.name)
+		}
+	}
+	s.steps = nil
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%w; rollback failed for: %s", err, strings.Join(failed, ", "))
+	}
+	return err
+}
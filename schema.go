@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+var schemaPrinter = message.NewPrinter(language.English)
+
+// PlanSchemas are the OSB schemas for the parameters a plan accepts.
+type PlanSchemas struct {
+	ServiceInstance ServiceInstanceSchemas `json:"service_instance"`
+	ServiceBinding  ServiceBindingSchemas  `json:"service_binding"`
+}
+
+type ServiceInstanceSchemas struct {
+	Create InputParameters `json:"create"`
+	Update InputParameters `json:"update"`
+}
+
+type ServiceBindingSchemas struct {
+	Create InputParameters `json:"create"`
+}
+
+type InputParameters struct {
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+}
+
+// ParameterSchema validates the parameters of one kind of request against a
+// plan's schema.
+type ParameterSchema struct {
+	schema *jsonschema.Schema
+}
+
+func compileSchema(location string, raw json.RawMessage) (ParameterSchema, error) {
+	if len(raw) == 0 {
+		return ParameterSchema{}, fmt.Errorf("no schema for %s", location)
+	}
+
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+	if err != nil {
+		return ParameterSchema{}, fmt.Errorf("invalid schema for %s: %s", location, err)
+	}
+
+	compiler := jsonschema.NewCompiler()
+	compiler.DefaultDraft(jsonschema.Draft4)
+	if err := compiler.AddResource(location, doc); err != nil {
+		return ParameterSchema{}, err
+	}
+	schema, err := compiler.Compile(location)
+	if err != nil {
+		return ParameterSchema{}, fmt.Errorf("invalid schema for %s: %s", location, err)
+	}
+	return ParameterSchema{schema: schema}, nil
+}
+
+// Validate returns an OSB error listing each field of params that the schema
+// rejects. Missing parameters are validated as an empty object.
+func (s ParameterSchema) Validate(params json.RawMessage) error {
+	if s.schema == nil {
+		return nil
+	}
+	if len(params) == 0 {
+		params = json.RawMessage(`{}`)
+	}
+
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(params))
+	if err != nil {
+		return invalidParameters(fmt.Errorf("Invalid parameters: %s", err))
+	}
+
+	err = s.schema.Validate(doc)
+	if err == nil {
+		return nil
+	}
+	validationErr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return invalidParameters(fmt.Errorf("Invalid parameters: %s", err))
+	}
+	messages := fieldErrors(validationErr)
+	sort.Strings(messages)
+	return invalidParameters(fmt.Errorf("Invalid parameters: %s", strings.Join(messages, "; ")))
+}
+
+// fieldErrors flattens a validation error into one message per rejected
+// field, each prefixed with the field's JSON pointer.
+func fieldErrors(err *jsonschema.ValidationError) []string {
+	location := "/" + strings.Join(err.InstanceLocation, "/")
+	if additional, ok := err.ErrorKind.(*kind.AdditionalProperties); ok {
+		messages := []string{}
+		for _, property := range additional.Properties {
+			messages = append(messages, fmt.Sprintf("%s: unknown parameter", path.Join(location, property)))
+		}
+		return messages
+	}
+	if len(err.Causes) == 0 {
+		return []string{fmt.Sprintf("%s: %s", location, err.ErrorKind.LocalizedString(schemaPrinter))}
+	}
+
+	messages := []string{}
+	for _, cause := range err.Causes {
+		messages = append(messages, fieldErrors(cause)...)
+	}
+	return messages
+}
+
+func invalidParameters(err error) error {
+	return brokerapi.NewFailureResponse(err, http.StatusBadRequest, "invalid-parameters")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParameterSchemaValidate(t *testing.T) {
+	schema, err := compileSchema("test/service_binding/create", json.RawMessage(`{
+		"$schema": "http://json-schema.org/draft-04/schema#",
+		"type": "object",
+		"properties": {
+			"redirect_uri": {"type": "array", "items": {"type": "string"}},
+			"allowpublic": {"type": "boolean"}
+		},
+		"additionalProperties": false
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		``:                                    "",
+		`{"redirect_uri": ["https://a.gov"]}`: "",
+		`{"redirect_uri": [1]}`:               "Invalid parameters: /redirect_uri/0: got number, want string",
+		`{"allowpublic": "yes", "scope": []}`: "Invalid parameters: /allowpublic: got string, want boolean; /scope: unknown parameter",
+		`[]`:                                  "Invalid parameters: /: got array, want object",
+	}
+	for params, expected := range cases {
+		err := schema.Validate(json.RawMessage(params))
+		if expected == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %s", params, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != expected {
+			t.Errorf("%s: expected %q, got %v", params, expected, err)
+		}
+	}
+
+	if err := schema.Validate(json.RawMessage(`{`)); err == nil {
+		t.Error("expected an error for invalid JSON")
+	}
+}
+
+func TestCompileSchemaRejectsInvalidSchemas(t *testing.T) {
+	if _, err := compileSchema("test/missing", nil); err == nil {
+		t.Error("expected an error for a missing schema")
+	}
+	if _, err := compileSchema("test/invalid", json.RawMessage(`{"type": "widget"}`)); err == nil {
+		t.Error("expected an error for an invalid schema")
+	}
+}
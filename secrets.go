@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// RotateClientSecret gives the client of a binding a new secret, keeping its
+// client ID. The client also accepts its previous secret until
+// RevokeClientSecret is called, so apps can move to the new one first.
+// Only the caller learns the new secret: the credentials that CF stored for
+// the binding keep the previous one. Bindings whose credentials are read from
+// CF should be rotated through predecessor_binding_id instead.
+func (b *DeployerAccountBroker) RotateClientSecret(
+	ctx context.Context,
+	instanceID, bindingID string,
+	details ClientSecretDetails,
+) (Binding, error) {
+	if err := b.checkClientBinding(ctx, instanceID, bindingID, details); err != nil {
+		return Binding{}, err
+	}
+
+	secret := b.generatePassword(b.config.PasswordLength)
+	err := b.uaaClient.AddClientSecret(ctx, bindingID, secret)
+	if errors.Is(err, ErrUAABadRequest) {
+		return Binding{}, brokerapi.NewFailureResponse(
+			fmt.Errorf("Client %s already has a previous secret; revoke it before rotating again", bindingID),
+			http.StatusConflict,
+			"rotate-client-secret",
+		)
+	}
+	if err != nil {
+		return Binding{}, err
+	}
+
+	return Binding{
+		Credentials: map[string]string{
+			"client_id":     bindingID,
+			"client_secret": secret,
+		},
+	}, nil
+}
+
+// RevokeClientSecret ends the grace period of a rotation, leaving the client
+// of a binding with only its newest secret.
+func (b *DeployerAccountBroker) RevokeClientSecret(
+	ctx context.Context,
+	instanceID, bindingID string,
+	details ClientSecretDetails,
+) error {
+	if err := b.checkClientBinding(ctx, instanceID, bindingID, details); err != nil {
+		return err
+	}
+
+	err := b.uaaClient.DeleteClientSecret(ctx, bindingID)
+	if errors.Is(err, ErrUAABadRequest) {
+		return brokerapi.NewFailureResponse(
+			fmt.Errorf("Client %s has no previous secret", bindingID),
+			http.StatusConflict,
+			"revoke-client-secret",
+		)
+	}
+	return err
+}
+
+// checkClientBinding returns an OSB error unless bindingID is a binding of
+// instanceID in CF under a plan whose credentials are a client secret. The
+// broker's UAA client may change the secret of any client in the zone.
+func (b *DeployerAccountBroker) checkClientBinding(
+	ctx context.Context,
+	instanceID, bindingID string,
+	details ClientSecretDetails,
+) error {
+	plan, err := b.plan(details.ServiceID, details.PlanID)
+	if err != nil {
+		return err
+	}
+	if plan.Kind != PlanKindClient && plan.Kind != PlanKindClientUser {
+		return brokerapi.NewFailureResponse(
+			fmt.Errorf("Plan %s does not issue client secrets", plan.PlanName),
+			http.StatusBadRequest,
+			"client-secret-plan",
+		)
+	}
+
+	bindings, err := b.cfClient.ServiceCredentialBindingsByInstanceGuid(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+	for _, binding := range bindings {
+		if binding.GUID == bindingID {
+			return nil
+		}
+	}
+	return brokerapi.NewFailureResponse(
+		fmt.Errorf("Binding %s not found", bindingID),
+		http.StatusNotFound,
+		"client-secret-binding",
+	)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+
+	cf "github.com/cloudfoundry/go-cfclient/v3/resource"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/cloud-gov/uaa-credentials-broker/mocks"
+)
+
+var _ = Describe("client secrets", func() {
+	var (
+		uaaClient FakeUAAClient
+		cfClient  mocks.PAASClient
+		broker    DeployerAccountBroker
+		details   ClientSecretDetails
+	)
+
+	BeforeEach(func() {
+		uaaClient = FakeUAAClient{}
+		cfClient = mocks.PAASClient{}
+		broker = newTestBroker(&uaaClient, &cfClient)
+		broker.generatePassword = func(int) string {
+			return "new-secret"
+		}
+		details = ClientSecretDetails{ServiceID: clientAccountGUID, PlanID: oauthClientGUID}
+
+		binding := &cf.ServiceCredentialBinding{}
+		binding.GUID = "binding-guid"
+		cfClient.On("ServiceCredentialBindingsByInstanceGuid", mock.Anything, "instance-guid").Return([]*cf.ServiceCredentialBinding{binding}, nil).Maybe()
+	})
+
+	Describe("RotateClientSecret", func() {
+		It("adds a new secret to the binding's client", func() {
+			uaaClient.On("AddClientSecret", "binding-guid", "new-secret").Return(nil)
+
+			binding, err := broker.RotateClientSecret(context.Background(), "instance-guid", "binding-guid", details)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.Credentials).To(Equal(map[string]string{
+				"client_id":     "binding-guid",
+				"client_secret": "new-secret",
+			}))
+			uaaClient.AssertExpectations(GinkgoT())
+		})
+
+		It("refuses to rotate again before the previous secret is revoked", func() {
+			uaaClient.On("AddClientSecret", "binding-guid", "new-secret").Return(&UAAError{StatusCode: 400})
+
+			_, err := broker.RotateClientSecret(context.Background(), "instance-guid", "binding-guid", details)
+			Expect(err).To(MatchError("Client binding-guid already has a previous secret; revoke it before rotating again"))
+		})
+
+		It("refuses clients that are not bindings of the instance", func() {
+			_, err := broker.RotateClientSecret(context.Background(), "instance-guid", "other-client", details)
+			Expect(err).To(MatchError("Binding other-client not found"))
+			uaaClient.AssertNotCalled(GinkgoT(), "AddClientSecret", mock.Anything, mock.Anything)
+		})
+
+		It("refuses plans without client secrets", func() {
+			details = ClientSecretDetails{ServiceID: userAccountGUID, PlanID: deployerGUID}
+
+			_, err := broker.RotateClientSecret(context.Background(), "instance-guid", "binding-guid", details)
+			Expect(err).To(MatchError("Plan space-deployer does not issue client secrets"))
+			uaaClient.AssertNotCalled(GinkgoT(), "AddClientSecret", mock.Anything, mock.Anything)
+		})
+	})
+
+	Describe("RevokeClientSecret", func() {
+		It("deletes the previous secret of the binding's client", func() {
+			uaaClient.On("DeleteClientSecret", "binding-guid").Return(nil)
+
+			Expect(broker.RevokeClientSecret(context.Background(), "instance-guid", "binding-guid", details)).To(Succeed())
+			uaaClient.AssertExpectations(GinkgoT())
+		})
+
+		It("reports a client without a previous secret", func() {
+			uaaClient.On("DeleteClientSecret", "binding-guid").Return(&UAAError{StatusCode: 400})
+
+			err := broker.RevokeClientSecret(context.Background(), "instance-guid", "binding-guid", details)
+			Expect(err).To(MatchError("Client binding-guid has no previous secret"))
+		})
+	})
+})
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryableStatuses are the responses after which a request is retried.
+var retryableStatuses = map[int]bool{
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+// RetryTransport is an http.RoundTripper that bounds each attempt with a
+// timeout and retries connection errors and retryable statuses with
+// exponential backoff and full jitter, honouring Retry-After up to MaxDelay.
+// It does not wait for a retry that could not start before the request's
+// deadline.
+type RetryTransport struct {
+	Base       http.RoundTripper
+	Timeout    time.Duration
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func NewRetryTransport(config Config) *RetryTransport {
+	return &RetryTransport{
+		Base:       http.DefaultTransport,
+		Timeout:    config.UAARequestTimeout,
+		MaxRetries: config.UAAMaxRetries,
+		BaseDelay:  config.UAARetryBaseDelay,
+		MaxDelay:   config.UAARetryMaxDelay,
+	}
+}
+
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := t.attempt(req)
+
+		if attempt >= t.MaxRetries || req.Context().Err() != nil {
+			return resp, err
+		}
+		if err == nil && !retryableStatuses[resp.StatusCode] {
+			return resp, nil
+		}
+		if req.Body != nil && req.GetBody == nil {
+			return resp, err
+		}
+
+		delay := t.backoff(attempt)
+		if resp != nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				delay = t.clamp(retryAfter)
+			}
+		}
+		// A retry that could not start before the deadline would only fail
+		if deadline, ok := req.Context().Deadline(); ok && time.Until(deadline) <= delay {
+			return resp, err
+		}
+		if resp != nil {
+			drainBody(resp.Body)
+		}
+
+		if err := t.wait(req.Context(), delay); err != nil {
+			return nil, err
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// attempt sends req once, cancelling it after t.Timeout. The timeout also
+// covers reading the response body.
+func (t *RetryTransport) attempt(req *http.Request) (*http.Response, error) {
+	if t.Timeout <= 0 {
+		return t.Base.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), t.Timeout)
+	resp, err := t.Base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+func (t *RetryTransport) backoff(attempt int) time.Duration {
+	delay := t.BaseDelay << uint(attempt)
+	if t.MaxDelay > 0 && (delay <= 0 || delay > t.MaxDelay) {
+		delay = t.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// clamp limits a delay asked for by the server to t.MaxDelay.
+func (t *RetryTransport) clamp(delay time.Duration) time.Duration {
+	if t.MaxDelay > 0 && delay > t.MaxDelay {
+		return t.MaxDelay
+	}
+	return delay
+}
+
+func (t *RetryTransport) wait(ctx context.Context, d time.Duration) error {
+	if t.sleep != nil {
+		return t.sleep(ctx, d)
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as
+// an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("retry transport", func() {
+	var (
+		server    *httptest.Server
+		transport *RetryTransport
+		requests  int32
+		statuses  []int
+		bodies    []string
+		headers   http.Header
+		delays    []time.Duration
+	)
+
+	BeforeEach(func() {
+		requests = 0
+		statuses = nil
+		bodies = nil
+		headers = http.Header{}
+		delays = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := int(atomic.AddInt32(&requests, 1)) - 1
+			body, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(body))
+			for k, v := range headers {
+				w.Header()[k] = v
+			}
+			status := http.StatusOK
+			if n < len(statuses) {
+				status = statuses[n]
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(`{}`))
+		}))
+		transport = &RetryTransport{
+			Base:       http.DefaultTransport,
+			Timeout:    time.Second,
+			MaxRetries: 3,
+			BaseDelay:  100 * time.Millisecond,
+			MaxDelay:   time.Second,
+			sleep: func(ctx context.Context, d time.Duration) error {
+				delays = append(delays, d)
+				return nil
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("retries retryable statuses and replays the request body", func() {
+		statuses = []int{http.StatusServiceUnavailable, http.StatusBadGateway}
+
+		req, _ := http.NewRequest("POST", server.URL, strings.NewReader(`{"client_id":"binding-guid"}`))
+		resp, err := (&http.Client{Transport: transport}).Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		drainBody(resp.Body)
+
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(requests).To(Equal(int32(3)))
+		Expect(bodies).To(Equal([]string{
+			`{"client_id":"binding-guid"}`,
+			`{"client_id":"binding-guid"}`,
+			`{"client_id":"binding-guid"}`,
+		}))
+		Expect(delays).To(HaveLen(2))
+		Expect(delays[0]).To(BeNumerically("<=", 100*time.Millisecond))
+		Expect(delays[1]).To(BeNumerically("<=", 200*time.Millisecond))
+	})
+
+	It("does not retry other statuses", func() {
+		statuses = []int{http.StatusConflict}
+
+		req, _ := http.NewRequest("POST", server.URL, strings.NewReader(`{}`))
+		resp, err := (&http.Client{Transport: transport}).Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		drainBody(resp.Body)
+
+		Expect(resp.StatusCode).To(Equal(http.StatusConflict))
+		Expect(requests).To(Equal(int32(1)))
+	})
+
+	It("waits as long as Retry-After asks", func() {
+		transport.MaxDelay = 5 * time.Second
+		statuses = []int{http.StatusTooManyRequests}
+		headers.Set("Retry-After", "2")
+
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		resp, err := (&http.Client{Transport: transport}).Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		drainBody(resp.Body)
+
+		Expect(requests).To(Equal(int32(2)))
+		Expect(delays).To(Equal([]time.Duration{2 * time.Second}))
+	})
+
+	It("waits no longer than the maximum delay", func() {
+		statuses = []int{http.StatusTooManyRequests}
+		headers.Set("Retry-After", "3600")
+
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		resp, err := (&http.Client{Transport: transport}).Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		drainBody(resp.Body)
+
+		Expect(requests).To(Equal(int32(2)))
+		Expect(delays).To(Equal([]time.Duration{time.Second}))
+	})
+
+	It("returns the response when a retry could not start before the deadline", func() {
+		statuses = []int{http.StatusTooManyRequests}
+		headers.Set("Retry-After", "1")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+		resp, err := (&http.Client{Transport: transport}).Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		drainBody(resp.Body)
+
+		Expect(resp.StatusCode).To(Equal(http.StatusTooManyRequests))
+		Expect(requests).To(Equal(int32(1)))
+		Expect(delays).To(BeEmpty())
+	})
+
+	It("returns the last response once retries are exhausted", func() {
+		statuses = []int{503, 503, 503, 503, 503}
+
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		resp, err := (&http.Client{Transport: transport}).Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		drainBody(resp.Body)
+
+		Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+		Expect(requests).To(Equal(int32(4)))
+	})
+
+	It("retries connection errors", func() {
+		attempts := 0
+		transport.Base = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			attempts++
+			if attempts == 1 {
+				return nil, errors.New("connection refused")
+			}
+			return http.DefaultTransport.RoundTrip(req)
+		})
+
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		resp, err := (&http.Client{Transport: transport}).Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		drainBody(resp.Body)
+
+		Expect(attempts).To(Equal(2))
+	})
+
+	It("times out slow attempts", func() {
+		transport.Timeout = 10 * time.Millisecond
+		transport.MaxRetries = 0
+		transport.Base = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		})
+
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		_, err := (&http.Client{Transport: transport}).Do(req)
+		Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+	})
+
+	It("parses Retry-After dates", func() {
+		delay, ok := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+		Expect(ok).To(BeTrue())
+		Expect(delay).To(BeNumerically("~", time.Minute, 2*time.Second))
+
+		_, ok = parseRetryAfter("soon")
+		Expect(ok).To(BeFalse())
+	})
+})
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
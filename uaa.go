@@ -1,13 +1,75 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 
 	"code.cloudfoundry.org/lager"
 )
 
+// Errors that a UAAError can be matched against with errors.Is.
+var (
+	ErrUAABadRequest   = errors.New("uaa: bad request")
+	ErrUAANotFound     = errors.New("uaa: not found")
+	ErrUAAConflict     = errors.New("uaa: conflict")
+	ErrUAAUnauthorized = errors.New("uaa: unauthorized")
+	ErrUAAForbidden    = errors.New("uaa: forbidden")
+	ErrUAARateLimited  = errors.New("uaa: rate limited")
+	ErrUAAServerError  = errors.New("uaa: server error")
+)
+
+// UAAError is returned by every UAAClient method when UAA responds with an
+// unexpected status.
+type UAAError struct {
+	StatusCode  int
+	ErrorCode   string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *UAAError) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("UAA returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("UAA returned status %d: %s", e.StatusCode, e.Description)
+}
+
+func (e *UAAError) Is(target error) bool {
+	switch target {
+	case ErrUAABadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUAANotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUAAConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrUAAUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrUAAForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrUAARateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrUAAServerError:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// checkStatus returns a UAAError, and closes the body, unless resp has the
+// expected status.
+func checkStatus(resp *http.Response, expected int) error {
+	if resp.StatusCode == expected {
+		return nil
+	}
+
+	uaaErr := &UAAError{}
+	decodeBody(resp.Body, uaaErr)
+	uaaErr.StatusCode = resp.StatusCode
+	return uaaErr
+}
+
 type Users struct {
 	Resources    []User
 	TotalResults int
@@ -32,11 +94,40 @@ type Client struct {
 	Name                 string   `json:"name,omitempty"`
 	AuthorizedGrantTypes []string `json:"authorized_grant_types,omitempty"`
 	Scope                []string `json:"scope,omitempty"`
+	Authorities          []string `json:"authorities,omitempty"`
 	RedirectURI          []string `json:"redirect_uri,omitempty"`
 	Active               bool     `json:"active,omitempty"`
 	AccessTokenValidity  int      `json:"access_token_validity,omitempty"`
 	RefreshTokenValidity int      `json:"refresh_token_validity,omitempty"`
 	AllowPublic          bool     `json:"allowpublic,omitempty"`
+	// BindDefaults and BindingExpiry are kept by UAA with the client's
+	// additional information.
+	BindDefaults  *BindOptions   `json:"bind_defaults,omitempty"`
+	BindingExpiry *bindingExpiry `json:"binding_expiry,omitempty"`
+}
+
+// uaaNone is the scope and authority that UAA reports for a client created
+// without any.
+const uaaNone = "uaa.none"
+
+// withoutPlaceholders returns the client with the uaaNone placeholder removed
+// from its scope and authorities, as they were requested.
+func (c Client) withoutPlaceholders() Client {
+	strip := func(values []string) []string {
+		stripped := []string{}
+		for _, value := range values {
+			if value != uaaNone {
+				stripped = append(stripped, value)
+			}
+		}
+		if len(stripped) == 0 {
+			return nil
+		}
+		return stripped
+	}
+	c.Scope = strip(c.Scope)
+	c.Authorities = strip(c.Authorities)
+	return c
 }
 
 type Email struct {
@@ -45,11 +136,17 @@ type Email struct {
 }
 
 type AuthClient interface {
-	CreateClient(client Client) (Client, error)
-	DeleteClient(clientID string) error
-	GetUser(userID string) (User, error)
-	CreateUser(user User) (User, error)
-	DeleteUser(userID string) error
+	GetClient(ctx context.Context, clientID string) (Client, error)
+	ListClients(ctx context.Context, filter string) ([]Client, error)
+	CreateClient(ctx context.Context, client Client) (Client, error)
+	ChangeClientSecret(ctx context.Context, clientID, secret string) error
+	AddClientSecret(ctx context.Context, clientID, secret string) error
+	DeleteClientSecret(ctx context.Context, clientID string) error
+	DeleteClient(ctx context.Context, clientID string) error
+	GetUser(ctx context.Context, userID string) (User, error)
+	CreateUser(ctx context.Context, user User) (User, error)
+	ChangeUserPassword(ctx context.Context, userID, password string) error
+	DeleteUser(ctx context.Context, userID string) error
 }
 
 type UAAClient struct {
@@ -59,11 +156,10 @@ type UAAClient struct {
 	zone     string
 }
 
-func (c *UAAClient) CreateClient(client Client) (Client, error) {
-	c.logger.Info("uaa-create-client", lager.Data{"clientID": client.ID})
+func (c *UAAClient) GetClient(ctx context.Context, clientID string) (Client, error) {
+	c.logger.Info("uaa-get-client", requestData(ctx, lager.Data{"clientID": clientID}))
 
-	body, _ := encodeBody(client)
-	req, _ := http.NewRequest("POST", fmt.Sprintf("%s/oauth/clients", c.endpoint), body)
+	req, _ := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/oauth/clients/%s", c.endpoint, clientID), nil)
 	req.Header.Add("X-Identity-Zone-Id", c.zone)
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Accept", "application/json")
@@ -72,13 +168,73 @@ func (c *UAAClient) CreateClient(client Client) (Client, error) {
 		return Client{}, err
 	}
 
-	if resp.StatusCode != 201 {
-		output := map[string]any{}
-		err = decodeBody(resp.Body, &output)
+	if err := checkStatus(resp, 200); err != nil {
+		return Client{}, err
+	}
+
+	client := Client{}
+	err = decodeBody(resp.Body, &client)
+	if err != nil {
+		return Client{}, err
+	}
+
+	return client, nil
+}
+
+// ListClients returns every client matching a SCIM filter, a page at a time.
+func (c *UAAClient) ListClients(ctx context.Context, filter string) ([]Client, error) {
+	c.logger.Info("uaa-list-clients", requestData(ctx, lager.Data{"filter": filter}))
+
+	found := []Client{}
+	for {
+		u, _ := url.Parse(fmt.Sprintf("%s/oauth/clients", c.endpoint))
+		q := u.Query()
+		q.Add("filter", filter)
+		q.Add("startIndex", strconv.Itoa(len(found)+1))
+		q.Add("count", "100")
+		u.RawQuery = q.Encode()
+
+		req, _ := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+		req.Header.Add("X-Identity-Zone-Id", c.zone)
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Accept", "application/json")
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkStatus(resp, 200); err != nil {
+			return nil, err
+		}
+
+		clients := Clients{}
+		err = decodeBody(resp.Body, &clients)
 		if err != nil {
-			return Client{}, err
+			return nil, err
+		}
+
+		found = append(found, clients.Resources...)
+		if len(clients.Resources) == 0 || len(found) >= clients.TotalResults {
+			return found, nil
 		}
-		return Client{}, fmt.Errorf("expected status 201; got: %d. error: %s", resp.StatusCode, output)
+	}
+}
+
+func (c *UAAClient) CreateClient(ctx context.Context, client Client) (Client, error) {
+	c.logger.Info("uaa-create-client", requestData(ctx, lager.Data{"clientID": client.ID}))
+
+	body, _ := encodeBody(client)
+	req, _ := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/oauth/clients", c.endpoint), body)
+	req.Header.Add("X-Identity-Zone-Id", c.zone)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return Client{}, err
+	}
+
+	if err := checkStatus(resp, 201); err != nil {
+		return Client{}, err
 	}
 
 	err = decodeBody(resp.Body, &client)
@@ -89,10 +245,11 @@ func (c *UAAClient) CreateClient(client Client) (Client, error) {
 	return client, nil
 }
 
-func (c *UAAClient) DeleteClient(clientID string) error {
-	c.logger.Info("uaa-delete-client", lager.Data{"clientID": clientID})
+func (c *UAAClient) ChangeClientSecret(ctx context.Context, clientID, secret string) error {
+	c.logger.Info("uaa-change-client-secret", requestData(ctx, lager.Data{"clientID": clientID}))
 
-	req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/oauth/clients/%s", c.endpoint, clientID), nil)
+	body, _ := encodeBody(map[string]string{"secret": secret})
+	req, _ := http.NewRequestWithContext(ctx, "PUT", fmt.Sprintf("%s/oauth/clients/%s/secret", c.endpoint, clientID), body)
 	req.Header.Add("X-Identity-Zone-Id", c.zone)
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Accept", "application/json")
@@ -100,15 +257,66 @@ func (c *UAAClient) DeleteClient(clientID string) error {
 	if err != nil {
 		return err
 	}
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("Expected status 200; got: %d", resp.StatusCode)
+	defer drainBody(resp.Body)
+
+	return checkStatus(resp, 200)
+}
+
+// secretChange is a request to UAA's client secret endpoint. UAA keeps at
+// most two secrets per client: ADD adds a second secret, and DELETE removes
+// the older of the two.
+type secretChange struct {
+	ClientID   string `json:"clientId"`
+	Secret     string `json:"secret,omitempty"`
+	ChangeMode string `json:"changeMode"`
+}
+
+// AddClientSecret adds secret to a client, which keeps accepting its current
+// secret until DeleteClientSecret is called.
+func (c *UAAClient) AddClientSecret(ctx context.Context, clientID, secret string) error {
+	c.logger.Info("uaa-add-client-secret", requestData(ctx, lager.Data{"clientID": clientID}))
+	return c.changeClientSecret(ctx, secretChange{ClientID: clientID, Secret: secret, ChangeMode: "ADD"})
+}
+
+// DeleteClientSecret removes the older of a client's two secrets.
+func (c *UAAClient) DeleteClientSecret(ctx context.Context, clientID string) error {
+	c.logger.Info("uaa-delete-client-secret", requestData(ctx, lager.Data{"clientID": clientID}))
+	return c.changeClientSecret(ctx, secretChange{ClientID: clientID, ChangeMode: "DELETE"})
+}
+
+func (c *UAAClient) changeClientSecret(ctx context.Context, change secretChange) error {
+	body, _ := encodeBody(change)
+	req, _ := http.NewRequestWithContext(ctx, "PUT", fmt.Sprintf("%s/oauth/clients/%s/secret", c.endpoint, change.ClientID), body)
+	req.Header.Add("X-Identity-Zone-Id", c.zone)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
 	}
+	defer drainBody(resp.Body)
 
-	return nil
+	return checkStatus(resp, 200)
 }
 
-func (c *UAAClient) GetUser(userID string) (User, error) {
-	c.logger.Info("uaa-get-user", lager.Data{"userID": userID})
+func (c *UAAClient) DeleteClient(ctx context.Context, clientID string) error {
+	c.logger.Info("uaa-delete-client", requestData(ctx, lager.Data{"clientID": clientID}))
+
+	req, _ := http.NewRequestWithContext(ctx, "DELETE", fmt.Sprintf("%s/oauth/clients/%s", c.endpoint, clientID), nil)
+	req.Header.Add("X-Identity-Zone-Id", c.zone)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer drainBody(resp.Body)
+
+	return checkStatus(resp, 200)
+}
+
+func (c *UAAClient) GetUser(ctx context.Context, userID string) (User, error) {
+	c.logger.Info("uaa-get-user", requestData(ctx, lager.Data{"userID": userID}))
 
 	u, _ := url.Parse(fmt.Sprintf("%s/Users", c.endpoint))
 	q := u.Query()
@@ -116,7 +324,7 @@ func (c *UAAClient) GetUser(userID string) (User, error) {
 	q.Add("count", "1")
 	u.RawQuery = q.Encode()
 
-	req, _ := http.NewRequest("GET", u.String(), nil)
+	req, _ := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
 	req.Header.Add("X-Identity-Zone-Id", c.zone)
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Accept", "application/json")
@@ -125,24 +333,37 @@ func (c *UAAClient) GetUser(userID string) (User, error) {
 		return User{}, err
 	}
 
+	if err := checkStatus(resp, 200); err != nil {
+		return User{}, err
+	}
+
 	users := Users{}
 	err = decodeBody(resp.Body, &users)
 	if err != nil {
 		return User{}, err
 	}
 
+	if users.TotalResults == 0 {
+		return User{}, &UAAError{
+			StatusCode:  http.StatusNotFound,
+			Description: fmt.Sprintf("User %s not found", userID),
+		}
+	}
 	if users.TotalResults != 1 {
-		return User{}, fmt.Errorf("Expected to find exactly one user; got %d", users.TotalResults)
+		return User{}, &UAAError{
+			StatusCode:  http.StatusConflict,
+			Description: fmt.Sprintf("Expected to find exactly one user %s; got %d", userID, users.TotalResults),
+		}
 	}
 
 	return users.Resources[0], nil
 }
 
-func (c *UAAClient) CreateUser(user User) (User, error) {
-	c.logger.Info("uaa-create-user", lager.Data{"userID": user.UserName})
+func (c *UAAClient) CreateUser(ctx context.Context, user User) (User, error) {
+	c.logger.Info("uaa-create-user", requestData(ctx, lager.Data{"userID": user.UserName}))
 
 	body, _ := encodeBody(user)
-	req, _ := http.NewRequest("POST", fmt.Sprintf("%s/Users", c.endpoint), body)
+	req, _ := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/Users", c.endpoint), body)
 	req.Header.Add("X-Identity-Zone-Id", c.zone)
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Accept", "application/json")
@@ -151,8 +372,8 @@ func (c *UAAClient) CreateUser(user User) (User, error) {
 		return User{}, err
 	}
 
-	if resp.StatusCode != 201 {
-		return User{}, fmt.Errorf("Expected status 201; got: %d", resp.StatusCode)
+	if err := checkStatus(resp, 201); err != nil {
+		return User{}, err
 	}
 
 	err = decodeBody(resp.Body, &user)
@@ -163,10 +384,11 @@ func (c *UAAClient) CreateUser(user User) (User, error) {
 	return user, nil
 }
 
-func (c *UAAClient) DeleteUser(userID string) error {
-	c.logger.Info("uaa-delete-user", lager.Data{"userID": userID})
+func (c *UAAClient) ChangeUserPassword(ctx context.Context, userID, password string) error {
+	c.logger.Info("uaa-change-user-password", requestData(ctx, lager.Data{"userID": userID}))
 
-	req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/Users/%s", c.endpoint, userID), nil)
+	body, _ := encodeBody(map[string]string{"password": password})
+	req, _ := http.NewRequestWithContext(ctx, "PUT", fmt.Sprintf("%s/Users/%s/password", c.endpoint, userID), body)
 	req.Header.Add("X-Identity-Zone-Id", c.zone)
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Accept", "application/json")
@@ -174,9 +396,23 @@ func (c *UAAClient) DeleteUser(userID string) error {
 	if err != nil {
 		return err
 	}
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("Expected status 200; got: %d", resp.StatusCode)
+	defer drainBody(resp.Body)
+
+	return checkStatus(resp, 200)
+}
+
+func (c *UAAClient) DeleteUser(ctx context.Context, userID string) error {
+	c.logger.Info("uaa-delete-user", requestData(ctx, lager.Data{"userID": userID}))
+
+	req, _ := http.NewRequestWithContext(ctx, "DELETE", fmt.Sprintf("%s/Users/%s", c.endpoint, userID), nil)
+	req.Header.Add("X-Identity-Zone-Id", c.zone)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
 	}
+	defer drainBody(resp.Body)
 
-	return nil
+	return checkStatus(resp, 200)
 }
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"code.cloudfoundry.org/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("uaa", func() {
+	var (
+		server *httptest.Server
+		client *UAAClient
+		status int
+		body   string
+
+		requestPath  string
+		requestQuery url.Values
+		requestBody  string
+	)
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			buf, _ := io.ReadAll(r.Body)
+			requestPath, requestQuery, requestBody = r.URL.Path, r.URL.Query(), string(buf)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}))
+		client = &UAAClient{
+			logger:   lagertest.NewTestLogger("uaa-test"),
+			client:   server.Client(),
+			endpoint: server.URL,
+			zone:     "uaa",
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("errors", func() {
+		It("returns a conflict with the uaa error description", func() {
+			status = http.StatusConflict
+			body = `{"error":"invalid_client","error_description":"Client already exists: binding-guid"}`
+
+			_, err := client.CreateClient(context.Background(), Client{ID: "binding-guid"})
+			Expect(errors.Is(err, ErrUAAConflict)).To(BeTrue())
+			Expect(errors.Is(err, ErrUAANotFound)).To(BeFalse())
+
+			var uaaErr *UAAError
+			Expect(errors.As(err, &uaaErr)).To(BeTrue())
+			Expect(uaaErr.StatusCode).To(Equal(http.StatusConflict))
+			Expect(uaaErr.ErrorCode).To(Equal("invalid_client"))
+			Expect(uaaErr.Description).To(Equal("Client already exists: binding-guid"))
+		})
+
+		It("returns not found when deleting a missing client", func() {
+			status = http.StatusNotFound
+			body = `{}`
+
+			err := client.DeleteClient(context.Background(), "binding-guid")
+			Expect(errors.Is(err, ErrUAANotFound)).To(BeTrue())
+		})
+
+		It("returns not found when no user matches", func() {
+			status = http.StatusOK
+			body = `{"resources":[],"totalResults":0}`
+
+			_, err := client.GetUser(context.Background(), "binding-guid")
+			Expect(errors.Is(err, ErrUAANotFound)).To(BeTrue())
+		})
+
+		It("returns a conflict when more than one user matches", func() {
+			status = http.StatusOK
+			body = `{"resources":[{"id":"user-guid"},{"id":"other-user-guid"}],"totalResults":2}`
+
+			_, err := client.GetUser(context.Background(), "binding-guid")
+			Expect(errors.Is(err, ErrUAAConflict)).To(BeTrue())
+			Expect(err).To(MatchError("UAA returned status 409: Expected to find exactly one user binding-guid; got 2"))
+		})
+
+		It("classifies rate limiting and server errors", func() {
+			status = http.StatusTooManyRequests
+			body = ``
+			err := client.DeleteUser(context.Background(), "user-guid")
+			Expect(errors.Is(err, ErrUAARateLimited)).To(BeTrue())
+
+			status = http.StatusBadGateway
+			err = client.DeleteUser(context.Background(), "user-guid")
+			Expect(errors.Is(err, ErrUAAServerError)).To(BeTrue())
+			Expect(err.Error()).To(Equal("UAA returned status 502"))
+		})
+
+		It("stops when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, err := client.GetClient(ctx, "binding-guid")
+			Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+		})
+
+		It("classifies authorization failures", func() {
+			status = http.StatusUnauthorized
+			body = `{"error":"unauthorized","error_description":"Bad credentials"}`
+			_, err := client.GetClient(context.Background(), "binding-guid")
+			Expect(errors.Is(err, ErrUAAUnauthorized)).To(BeTrue())
+
+			status = http.StatusForbidden
+			body = `{"error":"access_denied","error_description":"Access is denied"}`
+			err = client.ChangeClientSecret(context.Background(), "binding-guid", "secret")
+			Expect(errors.Is(err, ErrUAAForbidden)).To(BeTrue())
+		})
+	})
+
+	Describe("client secrets", func() {
+		BeforeEach(func() {
+			status = http.StatusOK
+			body = `{"status":"ok","message":"secret updated"}`
+		})
+
+		It("adds a second secret", func() {
+			Expect(client.AddClientSecret(context.Background(), "binding-guid", "new-secret")).To(Succeed())
+			Expect(requestPath).To(Equal("/oauth/clients/binding-guid/secret"))
+			Expect(requestBody).To(MatchJSON(`{"clientId":"binding-guid","secret":"new-secret","changeMode":"ADD"}`))
+		})
+
+		It("deletes the older secret", func() {
+			Expect(client.DeleteClientSecret(context.Background(), "binding-guid")).To(Succeed())
+			Expect(requestPath).To(Equal("/oauth/clients/binding-guid/secret"))
+			Expect(requestBody).To(MatchJSON(`{"clientId":"binding-guid","changeMode":"DELETE"}`))
+		})
+
+		It("returns a bad request when the client cannot take the change", func() {
+			status = http.StatusBadRequest
+			body = `{"error":"invalid_client","error_description":"client secret is either empty or client already has two secrets."}`
+
+			err := client.AddClientSecret(context.Background(), "binding-guid", "new-secret")
+			Expect(errors.Is(err, ErrUAABadRequest)).To(BeTrue())
+		})
+	})
+
+	Describe("ListClients", func() {
+		It("returns the clients matching a filter", func() {
+			status = http.StatusOK
+			body = `{"resources":[{"client_id":"binding-expiry-a","binding_expiry":{"plan_id":"plan-guid"}},{"client_id":"binding-expiry-b"}],"startIndex":1,"itemsPerPage":100,"totalResults":2}`
+
+			clients, err := client.ListClients(context.Background(), `client_id sw "binding-expiry-"`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(requestPath).To(Equal("/oauth/clients"))
+			Expect(requestQuery.Get("filter")).To(Equal(`client_id sw "binding-expiry-"`))
+			Expect(requestQuery.Get("startIndex")).To(Equal("1"))
+			Expect(clients).To(HaveLen(2))
+			Expect(clients[0].BindingExpiry.PlanID).To(Equal("plan-guid"))
+			Expect(clients[1].BindingExpiry).To(BeNil())
+		})
+	})
+})
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"io"
+	"sort"
 )
 
 func encodeBody(obj interface{}) (io.Reader, error) {
@@ -15,6 +16,52 @@ func encodeBody(obj interface{}) (io.Reader, error) {
 }
 
 func decodeBody(body io.ReadCloser, out interface{}) error {
-	defer body.Close()
+	defer drainBody(body)
 	return json.NewDecoder(body).Decode(out)
 }
+
+// drainBody reads body to EOF before closing it so that the underlying
+// connection can be reused.
+func drainBody(body io.ReadCloser) {
+	io.Copy(io.Discard, body)
+	body.Close()
+}
+
+// containsString reports whether s holds value.
+func containsString(s []string, value string) bool {
+	for _, v := range s {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// sameStrings reports whether a and b hold the same strings, ignoring order.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	x := append([]string{}, a...)
+	y := append([]string{}, b...)
+	sort.Strings(x)
+	sort.Strings(y)
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// withoutStrings returns the strings of s that are not in values.
+func withoutStrings(s, values []string) []string {
+	kept := []string{}
+	for _, v := range s {
+		if !containsString(values, v) {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}